@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"github.com/kuking/jbov/api/md"
+)
+
+// rename is replaceable so failures in the middle of a commit can be tested.
+var rename = os.Rename
+
+// transaction writes a set of files, usually spread across volumes, all or nothing. Every file is first staged in a
+// synced temporary file next to its final path; once all of them are staged they are renamed into place. If anything
+// fails the temporary files are removed and the files already renamed are restored to their previous content (or
+// removed if they did not exist).
+type transaction struct {
+	writes []*pendingWrite
+}
+
+type pendingWrite struct {
+	path      string
+	tmp       string
+	mode      os.FileMode
+	previous  []byte
+	existed   bool
+	committed bool
+}
+
+func (tx *transaction) stage(path string, data []byte, mode os.FileMode) error {
+	write := pendingWrite{path: path, mode: mode}
+	previous, err := ioutil.ReadFile(path)
+	if err == nil {
+		write.previous, write.existed = previous, true
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	write.tmp = f.Name()
+	tx.writes = append(tx.writes, &write)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// commit renames every staged file into place, rolling back on the first failure.
+func (tx *transaction) commit() error {
+	for _, write := range tx.writes {
+		if err := rename(write.tmp, write.path); err != nil {
+			tx.rollback()
+			return errors.New(fmt.Sprintf("Could not write \"%s\", changes rolled back: %s", write.path, err.Error()))
+		}
+		write.committed = true
+		if err := syncDir(filepath.Dir(write.path)); err != nil {
+			tx.rollback()
+			return errors.New(fmt.Sprintf("Could not sync \"%s\", changes rolled back: %s", write.path, err.Error()))
+		}
+	}
+	tx.writes = nil
+	return nil
+}
+
+// rollback removes the staged files and undoes the committed ones, it does its best and never fails.
+func (tx *transaction) rollback() {
+	for _, write := range tx.writes {
+		if !write.committed {
+			os.Remove(write.tmp)
+		} else if write.existed {
+			writeFileAtomic(write.path, write.previous, write.mode)
+		} else {
+			os.Remove(write.path)
+			syncDir(filepath.Dir(write.path))
+		}
+	}
+	tx.writes = nil
+}
+
+// writeFileAtomic replaces the file by writing a synced temporary file and renaming it into place.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tx := transaction{}
+	if err := tx.stage(path, data, mode); err != nil {
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// removeStagedFiles cleans the temporary metadata files left in the present volumes by interrupted transactions.
+func (handle *Handle) removeStagedFiles() {
+	for _, cname := range handle.Present() {
+		for _, fname := range []string{md.JBOV_FNAME, md.UNIQID_FNAME, md.LOCK_FNAME} {
+			staged, _ := filepath.Glob(filepath.Join(handle.Volumes[cname].MountPoint, fname+".tmp-*"))
+			for _, path := range staged {
+				os.Remove(path)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"testing"
+	"errors"
+	"os"
+	"path/filepath"
+	"io/ioutil"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic_replacesContent(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	ioutil.WriteFile(path, []byte("old"), 0644)
+
+	assert.NoError(t, writeFileAtomic(path, []byte("new"), 0600))
+
+	content, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "new", string(content))
+	assert.Equal(t, []string{path}, filesIn(dir))
+}
+
+func TestCreate_rollsBackEveryVolumeWhenAWriteFails(t *testing.T) {
+	jbov := givenValidJBOV()
+	givenMountPointsExist(&jbov)
+	defer cleanupMountPoints(&jbov)
+	defer givenRenameFailsIn(jbov.Volumes["vol2"].LastMountPoint)()
+
+	ok, err := Create(&jbov)
+
+	assert.False(t, ok)
+	assert.Error(t, err)
+	for _, vol := range jbov.Volumes {
+		assert.Empty(t, filesIn(vol.LastMountPoint))
+	}
+}
+
+func TestSave_restoresPreviousMetadataWhenAWriteFails(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	before := readMetadataCopy(handle.Volumes["vol1"].MountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.jpg", Ncopies: 2}}
+	defer givenRenameFailsIn(handle.Volumes["vol2"].MountPoint)()
+
+	err := handle.Save()
+
+	assert.Error(t, err)
+	for _, cname := range handle.Present() {
+		assert.Equal(t, before, readMetadataCopy(handle.Volumes[cname].MountPoint))
+		assert.Len(t, filesIn(handle.Volumes[cname].MountPoint), 2)
+	}
+}
+
+func TestOpen_removesFilesStagedByInterruptedWrites(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	staged := filepath.Join(jbov.Volumes["vol2"].LastMountPoint, md.JBOV_FNAME+".tmp-123")
+	ioutil.WriteFile(staged, []byte("half written"), 0644)
+
+	_, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	_, err = os.Stat(staged)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// utility
+
+func givenRenameFailsIn(dir string) func() {
+	rename = func(oldpath, newpath string) error {
+		if filepath.Dir(newpath) == dir {
+			return errors.New("disk unplugged")
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	return func() { rename = os.Rename }
+}
+
+func filesIn(dir string) []string {
+	files := []string{}
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		if !info.IsDir() {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	return files
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/md"
+)
+
+const SNAPSHOT_FORMAT = 1
+
+// Snapshot is a self-contained backup of a JBOV metadata, along with the uniqid found in every volume when exported
+// and, optionally, their file catalogs (by cname). It is sealed over its content so damaged snapshots are not restored:
+// with a signature, an HMAC keyed by the JBOV key, when there is one; which detects tampering too. Otherwise with a
+// plain sha256 checksum, anyone can recompute it, so it only detects damage.
+type Snapshot struct {
+	Format    int `json:"format"`
+	Created   int64 `json:"created"`
+	Host      string `json:"host"`
+	Metadata  json.RawMessage `json:"metadata"`
+	Uniqids   map[string]string `json:"uniqids"`
+	Catalogs  map[string]json.RawMessage `json:"catalogs,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// Signed tells if the snapshot is sealed by a signature, rather than by a checksum.
+func (snapshot *Snapshot) Signed() bool {
+	return strings.HasPrefix(snapshot.Signature, md.SIGNATURE_PREFIX)
+}
+
+// sign returns the snapshot seal, an HMAC keyed by the JBOV key when available or a plain checksum otherwise.
+func (snapshot *Snapshot) sign(key []byte) (string, error) {
+	unsigned := *snapshot
+	unsigned.Signature = ""
+	jsonb, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	if key != nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(jsonb)
+		return fmt.Sprintf("%s%x", md.SIGNATURE_PREFIX, mac.Sum(nil)), nil
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(jsonb)), nil
+}
+
+// Export writes a snapshot of the JBOV metadata in the given path, with the catalogs of the present volumes if asked
+// to. It returns the snapshot written.
+func (handle *Handle) Export(path string, withCatalogs bool) (*Snapshot, error) {
+	metadata, err := handle.Jbov.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	snapshot := Snapshot{
+		Format:   SNAPSHOT_FORMAT,
+		Created:  time.Now().Unix(),
+		Host:     host,
+		Metadata: metadata,
+		Uniqids:  make(map[string]string),
+	}
+	for _, cname := range handle.Present() {
+		snapshot.Uniqids[cname] = handle.Jbov.Volumes[cname].Uniqid
+	}
+	if withCatalogs {
+		snapshot.Catalogs = make(map[string]json.RawMessage)
+		for _, cname := range handle.Present() {
+			jsonb, err := ioutil.ReadFile(filepath.Join(handle.Volumes[cname].MountPoint, catalog.CATALOG_FNAME))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			snapshot.Catalogs[cname] = jsonb
+		}
+	}
+	if snapshot.Signature, err = snapshot.sign(handle.key); err != nil {
+		return nil, err
+	}
+	jsonb, err := json.MarshalIndent(snapshot, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, writeFileAtomic(path, jsonb, 0600)
+}
+
+// ReadSnapshot reads a snapshot, checking its signature and that its metadata loads.
+func ReadSnapshot(path string) (*Snapshot, *md.JBOV, error) {
+	jsonb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(jsonb, &snapshot); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Snapshot \"%s\" is not valid: %s", path, err.Error()))
+	}
+	if snapshot.Format != SNAPSHOT_FORMAT {
+		return nil, nil, errors.New(fmt.Sprintf("Snapshot \"%s\" format is not supported: %d", path, snapshot.Format))
+	}
+	jbov, _, err := md.Load(snapshot.Metadata)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Snapshot \"%s\" metadata can not be loaded: %s", path, err.Error()))
+	}
+	key, err := ReadKey(jbov.Uniqid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil && strings.HasPrefix(snapshot.Signature, md.SIGNATURE_PREFIX) {
+		return nil, nil, errors.New(fmt.Sprintf("Snapshot \"%s\" is signed but JBOV \"%s\" key is not available in this host", path, jbov.Cname))
+	}
+	if signature, err := snapshot.sign(key); err != nil || !hmac.Equal([]byte(signature), []byte(snapshot.Signature)) {
+		if !snapshot.Signed() {
+			return nil, nil, errors.New(fmt.Sprintf("Snapshot \"%s\" checksum does not match, it is damaged", path))
+		}
+		return nil, nil, errors.New(fmt.Sprintf("Snapshot \"%s\" signature does not verify, it is damaged or has been tampered", path))
+	}
+	return &snapshot, jbov, nil
+}
+
+// Restore re-stamps the metadata held in a snapshot onto the JBOV volumes. Volumes are looked for in the given mount
+// points (by cname), in their last known mount point or in the relocation search directories. A volume whose uniqid
+// file does not match the snapshot is refused, unless forced, in which case its uniqid file is rewritten too. The
+// catalogs in the snapshot are restored onto the volumes without one, the ones in place are newer.
+func Restore(path string, mountPoints map[string]string, force bool) (*Handle, error) {
+	snapshot, jbov, err := ReadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	for cname := range mountPoints {
+		if _, ok := jbov.Volumes[cname]; !ok {
+			return nil, errors.New(fmt.Sprintf("Volume \"%s\" is not part of JBOV \"%s\"", cname, jbov.Cname))
+		}
+	}
+
+	handle := Handle{Jbov: jbov, Volumes: make(map[string]*VolumeStatus)}
+	for cname, vol := range jbov.Volumes {
+		mountPoint, ok := mountPoints[cname]
+		if !ok {
+			mountPoint = vol.LastMountPoint
+		}
+		handle.Volumes[cname] = &VolumeStatus{Cname: cname, MountPoint: mountPoint, State: volumeState(mountPoint, vol)}
+	}
+	handle.relocate()
+	// an explicitly given mount point without uniqid file has lost it, it is restored as a mismatched one
+	for cname, mountPoint := range mountPoints {
+		if stat, err := os.Stat(mountPoint); err == nil && stat.IsDir() && handle.Volumes[cname].State == VOLUME_MISSING {
+			handle.Volumes[cname].State = VOLUME_MISMATCHED
+		}
+	}
+
+	mismatched := handle.Mismatched()
+	if len(mismatched) > 0 && !force {
+		return nil, errors.New(fmt.Sprintf("Volume(s) %v do not hold the uniqid found in the snapshot", mismatched))
+	}
+	extra := make(map[string][]byte)
+	for _, cname := range mismatched {
+		extra[filepath.Join(handle.Volumes[cname].MountPoint, md.UNIQID_FNAME)] = []byte(jbov.Volumes[cname].Uniqid)
+		handle.Volumes[cname].State = VOLUME_PRESENT
+	}
+	for cname, jsonb := range snapshot.Catalogs {
+		status, ok := handle.Volumes[cname]
+		if !ok || status.State != VOLUME_PRESENT || !isCatalogOf(jsonb, jbov.Volumes[cname].Uniqid) {
+			continue
+		}
+		if current, err := handle.Catalog(cname); err == nil && current.Scanned == 0 {
+			extra[filepath.Join(status.MountPoint, catalog.CATALOG_FNAME)] = jsonb
+		}
+	}
+	for cname, status := range handle.Volumes {
+		if status.State == VOLUME_PRESENT {
+			jbov.Volumes[cname].LastMountPoint = status.MountPoint
+		}
+	}
+
+	present := []string{}
+	for _, cname := range handle.Present() {
+		present = append(present, handle.Volumes[cname].MountPoint)
+	}
+	if len(present) == 0 {
+		return nil, errors.New(fmt.Sprintf("None of JBOV \"%s\" volumes has been found", jbov.Cname))
+	}
+	lock, err := handle.Lock("metadata restore")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	jbov.Touch()
+	if err := writeMetadata(jbov, present, extra); err != nil {
+		return nil, err
+	}
+	handle.Warnings = handle.checkRules()
+	return &handle, nil
+}
+
+// isCatalogOf tells if a marshaled catalog is a valid one of the given volume.
+func isCatalogOf(jsonb []byte, volumeUniqid string) bool {
+	volCatalog := catalog.Catalog{}
+	err := json.Unmarshal(jsonb, &volCatalog)
+	return err == nil && volCatalog.Format == catalog.CATALOG_FORMAT && volCatalog.Volume == volumeUniqid && volCatalog.Entries != nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+	"io/ioutil"
+	"strings"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportAndRestore_happyPath(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+	for _, cname := range handle.Present() {
+		os.Remove(filepath.Join(handle.Volumes[cname].MountPoint, md.JBOV_FNAME))
+	}
+
+	restored, err := Restore(snapshot, nil, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1", "vol2"}, restored.Present())
+	reopened, err := Open(handle.Volumes["vol2"].MountPoint)
+	assert.NoError(t, err)
+	assert.Equal(t, handle.Jbov.Uniqid, reopened.Jbov.Uniqid)
+	assert.True(t, reopened.Jbov.Generation > handle.Jbov.Generation)
+}
+
+func TestRestore_warnsAboutRulesWithAPatternNotWellFormed(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	handle.Jbov.Rules = []md.Rule{{Pattern: "size>big", Ncopies: 2}}
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+
+	restored, err := Restore(snapshot, nil, false)
+
+	assert.NoError(t, err)
+	assert.Len(t, restored.Warnings, 1)
+	assert.Equal(t, PROBLEM_INVALID_RULE, restored.Warnings[0].Kind)
+}
+
+func TestReadSnapshot_failsWhenTampered(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+	content, _ := ioutil.ReadFile(snapshot)
+	ioutil.WriteFile(snapshot, []byte(strings.Replace(string(content), "vol1", "vol3", -1)), 0600)
+
+	_, _, err := ReadSnapshot(snapshot)
+
+	assert.EqualError(t, err, "Snapshot \""+snapshot+"\" checksum does not match, it is damaged")
+}
+
+func TestReadSnapshot_signedWhenThereIsAKey(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+
+	read, _, err := ReadSnapshot(snapshot)
+	assert.NoError(t, err)
+	assert.True(t, read.Signed())
+
+	content, _ := ioutil.ReadFile(snapshot)
+	ioutil.WriteFile(snapshot, []byte(strings.Replace(string(content), "vol1", "vol3", -1)), 0600)
+	_, _, err = ReadSnapshot(snapshot)
+	assert.EqualError(t, err, "Snapshot \""+snapshot+"\" signature does not verify, it is damaged or has been tampered")
+}
+
+func TestExportAndRestore_catalogs(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	givenFileIn(handle.Jbov, "film.mk4", "film", "vol1", "vol2")
+	handle.Scan()
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+	read, _, _ := ReadSnapshot(snapshot)
+	assert.Len(t, read.Catalogs, 2)
+	os.Remove(filepath.Join(handle.Volumes["vol1"].MountPoint, catalog.CATALOG_FNAME))
+	vol2Catalog, _ := ioutil.ReadFile(filepath.Join(handle.Volumes["vol2"].MountPoint, catalog.CATALOG_FNAME))
+
+	_, err := Restore(snapshot, nil, false)
+
+	assert.NoError(t, err)
+	restored, _ := handle.Catalog("vol1")
+	assert.Contains(t, restored.Entries, "film.mk4")
+	kept, _ := ioutil.ReadFile(filepath.Join(handle.Volumes["vol2"].MountPoint, catalog.CATALOG_FNAME))
+	assert.Equal(t, vol2Catalog, kept)
+}
+
+func TestRestore_refusesMismatchedVolumesUnlessForced(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+	vol2 := handle.Volumes["vol2"].MountPoint
+	os.Remove(filepath.Join(vol2, md.UNIQID_FNAME))
+	mountPoints := map[string]string{"vol2": vol2}
+
+	_, err := Restore(snapshot, mountPoints, false)
+	assert.EqualError(t, err, "Volume(s) [vol2] do not hold the uniqid found in the snapshot")
+
+	restored, err := Restore(snapshot, mountPoints, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1", "vol2"}, restored.Present())
+	uniqid, _ := ioutil.ReadFile(filepath.Join(vol2, md.UNIQID_FNAME))
+	assert.Equal(t, handle.Jbov.Volumes["vol2"].Uniqid, string(uniqid))
+}
+
+func TestRestore_skipsMissingVolumes(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	defer givenSearchDirs([]string{})()
+	snapshot := givenExportedSnapshot(t, handle)
+	defer os.Remove(snapshot)
+	os.RemoveAll(handle.Volumes["vol2"].MountPoint)
+
+	restored, err := Restore(snapshot, nil, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, restored.Missing())
+}
+
+// utility
+
+func givenExportedSnapshot(t *testing.T, handle *Handle) string {
+	f, _ := ioutil.TempFile(os.TempDir(), "")
+	f.Close()
+	if _, err := handle.Export(f.Name(), true); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
@@ -0,0 +1,269 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"github.com/kuking/jbov/api/hashing"
+)
+
+// CATALOG_FNAME is the catalog file, kept in the volume root next to the metadata.
+const CATALOG_FNAME = ".jbov.catalog"
+
+const CATALOG_FORMAT = 1
+
+// JBOV_PREFIX is the prefix of every file jbov keeps in a volume root, they are not part of the catalog.
+const JBOV_PREFIX = ".jbov"
+
+// TMP_PREFIX is the prefix of the temporary files jbov writes next to the files it copies, in any directory; they are
+// not part of the catalog either.
+const TMP_PREFIX = ".jbov.tmp-"
+
+// Entry describes a file in a volume, as it was when last scanned. The content hash is optional, it is dropped when
+// the file changes; Verified is when the content was last checked against it (unix nanoseconds), and Damaged whether
+// it did not match then.
+type Entry struct {
+	Size     int64 `json:"size"`
+	Mode     os.FileMode `json:"mode"`
+	Mtime    int64 `json:"mtime"`
+	Inode    uint64 `json:"inode"`
+	Hash     string `json:"hash,omitempty"`
+	Verified int64 `json:"verified,omitempty"`
+	Damaged  bool `json:"damaged,omitempty"`
+}
+
+// Catalog lists the files held by a volume, by their slash separated path relative to the volume root. Pass is when
+// the verification pass in progress started (unix nanoseconds), zero when there is none.
+type Catalog struct {
+	Format  int `json:"format"`
+	Volume  string `json:"volume"`
+	Scanned int64 `json:"scanned"`
+	Pass    int64 `json:"pass,omitempty"`
+	Entries map[string]*Entry `json:"entries"`
+}
+
+// Stats summarises what a scan found compared to the previous catalog.
+type Stats struct {
+	Files     int
+	Bytes     int64
+	Added     int
+	Changed   int
+	Unchanged int
+	Removed   int
+}
+
+// EntryOf describes a file out of its (lstat) info, without hash.
+func EntryOf(info os.FileInfo) *Entry {
+	return &Entry{Size: info.Size(), Mode: info.Mode(), Mtime: info.ModTime().UnixNano(), Inode: inodeOf(info)}
+}
+
+// IsSameFileAs tells whether both entries describe the same, unchanged, file; the hash is not compared.
+func (entry *Entry) IsSameFileAs(other *Entry) bool {
+	return entry.Size == other.Size && entry.Mode == other.Mode && entry.Mtime == other.Mtime && entry.Inode == other.Inode
+}
+
+func New(volumeUniqid string) *Catalog {
+	return &Catalog{Format: CATALOG_FORMAT, Volume: volumeUniqid, Entries: make(map[string]*Entry)}
+}
+
+// Load reads the catalog kept in the volume. The catalog is a cache: when there is none, or it is damaged or belongs
+// to another volume, an empty one is returned so the next scan rebuilds it.
+func Load(mountPoint string, volumeUniqid string) (*Catalog, error) {
+	jsonb, err := ioutil.ReadFile(filepath.Join(mountPoint, CATALOG_FNAME))
+	if os.IsNotExist(err) {
+		return New(volumeUniqid), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	catalog := Catalog{}
+	if err := json.Unmarshal(jsonb, &catalog); err != nil || catalog.Format != CATALOG_FORMAT || catalog.Volume != volumeUniqid || catalog.Entries == nil {
+		return New(volumeUniqid), nil
+	}
+	return &catalog, nil
+}
+
+// Save writes the catalog in the volume, replacing the previous one atomically.
+func (catalog *Catalog) Save(mountPoint string) error {
+	jsonb, err := json.Marshal(catalog)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(mountPoint, CATALOG_FNAME)
+	f, err := ioutil.TempFile(mountPoint, CATALOG_FNAME+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(jsonb); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Paths returns the catalogued paths, sorted.
+func (catalog *Catalog) Paths() []string {
+	paths := make([]string, 0, len(catalog.Entries))
+	for path := range catalog.Entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Scan walks the volume and returns its up to date catalog. Files whose size, mode, mtime and inode did not change
+// since the previous catalog keep their entry (and hash) without being examined any further; only regular files and
+// symlinks are catalogued.
+func Scan(mountPoint string, previous *Catalog) (*Catalog, *Stats, error) {
+	catalog := New(previous.Volume)
+	catalog.Pass = previous.Pass
+	stats := Stats{}
+	err := filepath.Walk(mountPoint, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(mountPoint, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if filepath.Dir(rel) == "." && strings.HasPrefix(info.Name(), JBOV_PREFIX) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if (!info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0) || strings.HasPrefix(info.Name(), TMP_PREFIX) {
+			return nil
+		}
+
+		rel = filepath.ToSlash(rel)
+		entry := EntryOf(info)
+		if known, ok := previous.Entries[rel]; !ok {
+			stats.Added++
+		} else if known.IsSameFileAs(entry) {
+			entry = known
+			stats.Unchanged++
+		} else {
+			stats.Changed++
+		}
+		catalog.Entries[rel] = entry
+		stats.Files++
+		stats.Bytes += entry.Size
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for path := range previous.Entries {
+		if _, ok := catalog.Entries[path]; !ok {
+			stats.Removed++
+		}
+	}
+	catalog.Scanned = time.Now().Unix()
+	return catalog, &stats, nil
+}
+
+// Hash returns the content hash of a file in the volume, given its catalog path. The hash cached in the catalog is
+// used when the file has not changed and it was computed with the same algorithm, otherwise the file is hashed and
+// its entry updated; cached is true when the file has not been read.
+func (catalog *Catalog) Hash(mountPoint string, path string, algorithm string) (sum string, cached bool, err error) {
+	fullPath := filepath.Join(mountPoint, filepath.FromSlash(path))
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return "", false, err
+	}
+	entry := EntryOf(info)
+	if known, ok := catalog.Entries[path]; ok && known.IsSameFileAs(entry) && known.Hash != "" && hashing.AlgorithmOf(known.Hash) == algorithm {
+		return known.Hash, true, nil
+	}
+	if sum, err = hashing.File(algorithm, fullPath); err != nil {
+		return "", false, err
+	}
+	// the file might have changed while being hashed, it is hashed again next time
+	if info, err := os.Lstat(fullPath); err == nil && EntryOf(info).IsSameFileAs(entry) {
+		entry.Hash = sum
+		entry.Verified = time.Now().UnixNano()
+		catalog.Entries[path] = entry
+	}
+	return sum, false, nil
+}
+
+// Verify reads a catalogued file again and checks its content against the hash in the catalog, or hashes it when
+// there is no hash for it yet. It returns false when the content no longer matches but the file has not changed (its
+// size, mode, mtime and inode are the catalogued ones): bit rot. The catalogued hash is kept, so it can be repaired;
+// the entry is marked as verified either way, and as damaged when it does not match.
+func (catalog *Catalog) Verify(mountPoint string, path string, algorithm string) (bool, error) {
+	entry, ok := catalog.Entries[path]
+	if !ok {
+		return false, errors.New(fmt.Sprintf("\"%s\" is not in the catalog", path))
+	}
+	if entry.Hash == "" || hashing.AlgorithmOf(entry.Hash) != algorithm {
+		_, _, err := catalog.Hash(mountPoint, path, algorithm)
+		return true, err
+	}
+	fullPath := filepath.Join(mountPoint, filepath.FromSlash(path))
+	sum, err := hashing.File(algorithm, fullPath)
+	if err != nil {
+		return false, err
+	}
+	// changed since catalogued, it is not bit rot
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		delete(catalog.Entries, path)
+		return true, nil
+	}
+	if current := EntryOf(info); !current.IsSameFileAs(entry) {
+		catalog.Entries[path] = current
+		return true, nil
+	}
+	entry.Verified = time.Now().UnixNano()
+	entry.Damaged = sum != entry.Hash
+	return !entry.Damaged, nil
+}
+
+// Unverified returns the paths not verified since the given time (unix nanoseconds), the least recently verified first.
+func (catalog *Catalog) Unverified(since int64) []string {
+	paths := []string{}
+	for path, entry := range catalog.Entries {
+		if entry.Verified < since {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := catalog.Entries[paths[i]], catalog.Entries[paths[j]]
+		if a.Verified != b.Verified {
+			return a.Verified < b.Verified
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// Fingerprint identifies the files catalogued, as they were when scanned: it changes when any file is added, removed
+// or modified; but not when they are hashed or verified.
+func (catalog *Catalog) Fingerprint() string {
+	h := sha256.New()
+	for _, path := range catalog.Paths() {
+		entry := catalog.Entries[path]
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\x00%d\n", path, entry.Size, entry.Mode, entry.Mtime, entry.Inode)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
@@ -0,0 +1,215 @@
+package catalog
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"github.com/kuking/jbov/api/hashing"
+	"github.com/stretchr/testify/assert"
+)
+
+const VOLUME = "VOL:0123456789abcdef0123"
+
+func TestScan_catalogsEveryFile(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+
+	catalog, stats, err := Scan(dir, New(VOLUME))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "sub/b.txt"}, catalog.Paths())
+	assert.Equal(t, int64(5), catalog.Entries["sub/b.txt"].Size)
+	assert.NotZero(t, catalog.Entries["a.txt"].Mtime)
+	assert.Equal(t, VOLUME, catalog.Volume)
+	assert.Equal(t, Stats{Files: 2, Bytes: 6, Added: 2}, *stats)
+}
+
+func TestScan_ignoresJbovFiles(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, ".jbov.metadata"), []byte("{}"), 0644)
+	os.Mkdir(filepath.Join(dir, ".jbov.history"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".jbov.history", "1.json"), []byte("{}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", ".jbov.metadata"), []byte("{}"), 0644)
+
+	catalog, _, err := Scan(dir, New(VOLUME))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "sub/.jbov.metadata", "sub/b.txt"}, catalog.Paths())
+}
+
+func TestScan_keepsUnchangedEntries(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	previous, _, _ := Scan(dir, New(VOLUME))
+	previous.Entries["a.txt"].Hash = "sha256:aa"
+	previous.Entries["sub/b.txt"].Hash = "sha256:bb"
+
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("changed"), 0644)
+	catalog, stats, err := Scan(dir, previous)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:aa", catalog.Entries["a.txt"].Hash)
+	assert.Equal(t, "", catalog.Entries["sub/b.txt"].Hash)
+	assert.Equal(t, int64(7), catalog.Entries["sub/b.txt"].Size)
+	assert.Equal(t, Stats{Files: 2, Bytes: 8, Changed: 1, Unchanged: 1}, *stats)
+}
+
+func TestScan_detectsChangedMtime(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	previous, _, _ := Scan(dir, New(VOLUME))
+
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	_, stats, err := Scan(dir, previous)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Changed)
+}
+
+func TestScan_detectsAddedAndRemovedFiles(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	previous, _, _ := Scan(dir, New(VOLUME))
+
+	os.Remove(filepath.Join(dir, "a.txt"))
+	ioutil.WriteFile(filepath.Join(dir, "c.txt"), []byte("cc"), 0644)
+	catalog, stats, err := Scan(dir, previous)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c.txt", "sub/b.txt"}, catalog.Paths())
+	assert.Equal(t, Stats{Files: 2, Bytes: 7, Added: 1, Unchanged: 1, Removed: 1}, *stats)
+}
+
+func TestSaveAndLoad_roundTrip(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	catalog, _, _ := Scan(dir, New(VOLUME))
+	catalog.Entries["a.txt"].Hash = "sha256:aa"
+
+	assert.NoError(t, catalog.Save(dir))
+	loaded, err := Load(dir, VOLUME)
+
+	assert.NoError(t, err)
+	assert.Equal(t, catalog, loaded)
+}
+
+func TestLoad_startsAfreshWhenThereIsNoUsableCatalog(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+
+	loaded, err := Load(dir, VOLUME)
+	assert.NoError(t, err)
+	assert.Empty(t, loaded.Entries)
+
+	catalog, _, _ := Scan(dir, New("VOL:ffffffffffffffffffff"))
+	catalog.Save(dir)
+	loaded, err = Load(dir, VOLUME)
+	assert.NoError(t, err)
+	assert.Empty(t, loaded.Entries)
+
+	ioutil.WriteFile(filepath.Join(dir, CATALOG_FNAME), []byte("damaged"), 0644)
+	loaded, err = Load(dir, VOLUME)
+	assert.NoError(t, err)
+	assert.Empty(t, loaded.Entries)
+	assert.Equal(t, VOLUME, loaded.Volume)
+}
+
+func TestHash_isCachedUntilTheFileChanges(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	catalog, _, _ := Scan(dir, New(VOLUME))
+
+	sum, cached, err := catalog.Hash(dir, "sub/b.txt", hashing.SHA256)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, sum, catalog.Entries["sub/b.txt"].Hash)
+
+	again, cached, err := catalog.Hash(dir, "sub/b.txt", hashing.SHA256)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, sum, again)
+
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("changed"), 0644)
+	changed, cached, err := catalog.Hash(dir, "sub/b.txt", hashing.SHA256)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.NotEqual(t, sum, changed)
+}
+
+func TestHash_isRecomputedWithAnotherAlgorithm(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	catalog, _, _ := Scan(dir, New(VOLUME))
+	catalog.Hash(dir, "a.txt", hashing.SHA256)
+
+	sum, cached, err := catalog.Hash(dir, "a.txt", hashing.XXHASH)
+
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, hashing.XXHASH, hashing.AlgorithmOf(sum))
+	assert.Equal(t, sum, catalog.Entries["a.txt"].Hash)
+}
+
+func TestVerify_detectsContentChangedInAnUnmodifiedFile(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	catalog, _, _ := Scan(dir, New(VOLUME))
+	ok, err := catalog.Verify(dir, "a.txt", hashing.SHA256)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	stored := catalog.Entries["a.txt"].Hash
+
+	path := filepath.Join(dir, "a.txt")
+	stat, _ := os.Stat(path)
+	ioutil.WriteFile(path, []byte("b"), 0644)
+	os.Chtimes(path, stat.ModTime(), stat.ModTime())
+	ok, err = catalog.Verify(dir, "a.txt", hashing.SHA256)
+
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, stored, catalog.Entries["a.txt"].Hash)
+	assert.True(t, catalog.Entries["a.txt"].Damaged)
+}
+
+func TestVerify_modifiedFileIsNotBitRot(t *testing.T) {
+	dir := givenVolumeWithFiles(t)
+	defer os.RemoveAll(dir)
+	catalog, _, _ := Scan(dir, New(VOLUME))
+	catalog.Verify(dir, "a.txt", hashing.SHA256)
+
+	later := time.Now().Add(time.Hour)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("b"), 0644)
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	ok, err := catalog.Verify(dir, "a.txt", hashing.SHA256)
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "", catalog.Entries["a.txt"].Hash)
+}
+
+func TestUnverified_leastRecentlyVerifiedFirst(t *testing.T) {
+	catalog := New(VOLUME)
+	catalog.Entries["never"] = &Entry{}
+	catalog.Entries["old"] = &Entry{Verified: 10}
+	catalog.Entries["older"] = &Entry{Verified: 5}
+	catalog.Entries["recent"] = &Entry{Verified: 100}
+
+	assert.Equal(t, []string{"never", "older", "old"}, catalog.Unverified(100))
+}
+
+// utility
+
+func givenVolumeWithFiles(t *testing.T) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bbbbb"), 0644)
+	return dir
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package catalog
+
+import (
+	"os"
+)
+
+// inodeOf is not available in this platform, changes are detected by size, mode and mtime only.
+func inodeOf(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package catalog
+
+import (
+	"os"
+	"syscall"
+)
+
+func inodeOf(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
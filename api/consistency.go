@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"github.com/kuking/jbov/api/md"
+)
+
+type ProblemKind int
+
+const (
+	PROBLEM_UNIQID_MISMATCH  ProblemKind = iota
+	PROBLEM_FOREIGN_METADATA
+	PROBLEM_SAME_DISK
+	PROBLEM_SAME_FILESYSTEM
+	PROBLEM_INTERRUPTED
+	PROBLEM_UNREADABLE_METADATA
+	PROBLEM_INVALID_RULE
+	PROBLEM_UNVERIFIED_SIGNATURE
+)
+
+// Problem is an inconsistency found in a volume, jbov refuses to write to volumes with problems. Some are reported
+// only as warnings (PROBLEM_SAME_FILESYSTEM, PROBLEM_INTERRUPTED, PROBLEM_INVALID_RULE, PROBLEM_UNVERIFIED_SIGNATURE),
+// which do not prevent writing; the ones of the JBOV as a whole have no volume cname.
+type Problem struct {
+	Kind   ProblemKind
+	Cname  string
+	Detail string
+}
+
+func (problem Problem) String() string {
+	if problem.Cname == "" {
+		return problem.Detail
+	}
+	return fmt.Sprintf("volume \"%s\": %s", problem.Cname, problem.Detail)
+}
+
+// CheckConsistency verifies every volume is what the metadata says it is: its uniqid file holds the expected uniqid,
+// its metadata (if any) belongs to this JBOV and it is not the same directory as another volume. Metadata in a schema
+// version, or with fields, unknown to this version of jbov can not be told apart from another JBOV's, so it is a problem
+// too; damaged metadata is not, it is rewritten on open. Problems are sorted by volume cname.
+func (handle *Handle) CheckConsistency() []Problem {
+	problems := []Problem{}
+	for cname, status := range handle.Volumes {
+		if status.State == VOLUME_MISMATCHED {
+			uniqid, _ := ioutil.ReadFile(filepath.Join(status.MountPoint, md.UNIQID_FNAME))
+			problems = append(problems, Problem{PROBLEM_UNIQID_MISMATCH, cname,
+				fmt.Sprintf("%s holds uniqid \"%s\", expected \"%s\"", status.MountPoint, uniqid, handle.Jbov.Volumes[cname].Uniqid)})
+		}
+	}
+
+	for _, cname := range handle.Present() {
+		jsonb, err := ioutil.ReadFile(filepath.Join(handle.Volumes[cname].MountPoint, md.JBOV_FNAME))
+		if err != nil {
+			continue
+		}
+		jbov, _, err := md.Load(jsonb)
+		if loadErr, ok := err.(*md.LoadError); ok && (loadErr.Kind == md.LOAD_UNSUPPORTED_VERSION || loadErr.Kind == md.LOAD_UNKNOWN_FIELD) {
+			problems = append(problems, Problem{PROBLEM_UNREADABLE_METADATA, cname, "holds metadata that can not be loaded, " + err.Error()})
+			continue
+		}
+		if err == nil && jbov.Uniqid != handle.Jbov.Uniqid {
+			problems = append(problems, Problem{PROBLEM_FOREIGN_METADATA, cname,
+				fmt.Sprintf("holds the metadata of another JBOV \"%s\" (%s)", jbov.Cname, jbov.Uniqid)})
+		}
+	}
+
+	cnames := []string{}
+	stats := make(map[string]os.FileInfo)
+	for cname, status := range handle.Volumes {
+		if stat, err := os.Stat(status.MountPoint); err == nil {
+			cnames = append(cnames, cname)
+			stats[cname] = stat
+		}
+	}
+	sort.Strings(cnames)
+	for i, cname := range cnames {
+		for _, other := range cnames[i+1:] {
+			if os.SameFile(stats[cname], stats[other]) {
+				detail := fmt.Sprintf("%s is the same directory as volume \"%s\"", handle.Volumes[cname].MountPoint, other)
+				problems = append(problems, Problem{PROBLEM_SAME_DISK, cname, detail})
+				detail = fmt.Sprintf("%s is the same directory as volume \"%s\"", handle.Volumes[other].MountPoint, cname)
+				problems = append(problems, Problem{PROBLEM_SAME_DISK, other, detail})
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Cname < problems[j].Cname })
+	return problems
+}
+
+// Writable returns the present volumes without consistency problems, sorted.
+func (handle *Handle) Writable() []string {
+	troubled := make(map[string]bool)
+	for _, problem := range handle.Problems {
+		troubled[problem.Cname] = true
+	}
+	writable := []string{}
+	for _, cname := range handle.Present() {
+		if !troubled[cname] {
+			writable = append(writable, cname)
+		}
+	}
+	return writable
+}
+
+// checkRules warns about the rules whose pattern is not well formed, i.e. written before the pattern language changed,
+// merged or restored; they match nothing until replaced.
+func (handle *Handle) checkRules() []Problem {
+	warnings := []Problem{}
+	for i := range handle.Jbov.Rules {
+		if err := handle.Jbov.Rules[i].PatternError(); err != nil {
+			warnings = append(warnings, Problem{PROBLEM_INVALID_RULE, "", fmt.Sprintf("rule %d matches nothing, %s", i, err)})
+		}
+	}
+	return warnings
+}
+
+// checkSignature warns when the metadata is signed but its key is not available, so its signature is not verified.
+func (handle *Handle) checkSignature() []Problem {
+	if handle.key != nil || handle.Jbov.Signature == "" {
+		return []Problem{}
+	}
+	return []Problem{{PROBLEM_UNVERIFIED_SIGNATURE, "", "metadata is signed but its signature is not verified, " + keyUnavailable(handle.Jbov.Uniqid)}}
+}
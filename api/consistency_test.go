@@ -0,0 +1,82 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+	"io/ioutil"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConsistency_happyPath(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+
+	assert.Empty(t, handle.CheckConsistency())
+	assert.Equal(t, []string{"vol1", "vol2"}, handle.Writable())
+}
+
+func TestCheckConsistency_swappedUniqid(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, md.UNIQID_FNAME), []byte("VOL:bad"), 0644)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Len(t, handle.Problems, 1)
+	assert.Equal(t, PROBLEM_UNIQID_MISMATCH, handle.Problems[0].Kind)
+	assert.Equal(t, "volume \"vol2\": "+jbov.Volumes["vol2"].LastMountPoint+" holds uniqid \"VOL:bad\", expected \""+jbov.Volumes["vol2"].Uniqid+"\"", handle.Problems[0].String())
+}
+
+func TestCheckConsistency_foreignMetadataIsNeverOverwritten(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	foreign := givenValidJBOV()
+	foreign.Cname = "foreign"
+	givenMetadataCopy(&foreign, jbov.Volumes["vol2"].LastMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Problem{{PROBLEM_FOREIGN_METADATA, "vol2", "holds the metadata of another JBOV \"foreign\" (" + foreign.Uniqid + ")"}}, handle.Problems)
+	assert.Equal(t, []string{"vol1"}, handle.Writable())
+	assert.NoError(t, handle.Save())
+	assert.Equal(t, "foreign", readMetadataCopy(jbov.Volumes["vol2"].LastMountPoint).Cname)
+}
+
+func TestCheckConsistency_metadataInAnUnknownSchemaVersionIsNeverOverwritten(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	newer := []byte(`{ "schema-version": 999, "cname": "newer" }`)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, md.JBOV_FNAME), newer, 0644)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Problem{{PROBLEM_UNREADABLE_METADATA, "vol2",
+		"holds metadata that can not be loaded, JBOV metadata schema version is not supported: 999"}}, handle.Problems)
+	assert.Equal(t, []string{"vol1"}, handle.Writable())
+	raw, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, md.JBOV_FNAME))
+	assert.Equal(t, newer, raw)
+}
+
+func TestCheckConsistency_twoVolumesInTheSameDirectory(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	link := jbov.Volumes["vol2"].LastMountPoint + ".link"
+	os.Symlink(jbov.Volumes["vol1"].LastMountPoint, link)
+	defer os.Remove(link)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.NoError(t, err)
+	handle.Volumes["vol2"].MountPoint = link
+	problems := handle.CheckConsistency()
+
+	assert.Len(t, problems, 2)
+	assert.Equal(t, PROBLEM_SAME_DISK, problems[0].Kind)
+	assert.Equal(t, "vol1", problems[0].Cname)
+	assert.Equal(t, "vol2", problems[1].Cname)
+}
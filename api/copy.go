@@ -0,0 +1,64 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"github.com/kuking/jbov/api/catalog"
+)
+
+// stageCopy copies a file (or symlink) into a synced temporary file next to its destination, keeping its mode and
+// times, and returns the temporary file path. The caller verifies it and renames it into place, or removes it.
+func stageCopy(src string, dst string) (string, error) {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return "", err
+		}
+		tmp := filepath.Join(filepath.Dir(dst), catalog.TMP_PREFIX+filepath.Base(dst))
+		os.Remove(tmp)
+		return tmp, os.Symlink(target, tmp)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(dst), catalog.TMP_PREFIX+filepath.Base(dst)+"-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := os.Chtimes(out.Name(), info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
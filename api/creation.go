@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"path/filepath"
 	"github.com/kuking/jbov/api/md"
-	"io/ioutil"
-	"strconv"
 )
 
 func CanCreate(jbov *md.JBOV) (bool, error) {
@@ -40,6 +38,16 @@ func CanCreate(jbov *md.JBOV) (bool, error) {
 		}
 	}
 
+	if !AllowSameFilesystem {
+		mountPoints := make(map[string]string)
+		for cname, volume := range jbov.Volumes {
+			mountPoints[cname] = volume.LastMountPoint
+		}
+		if err := colocatedError(colocatedVolumes(mountPoints)); err != nil {
+			return false, err
+		}
+	}
+
 	return true, nil
 }
 
@@ -49,24 +57,19 @@ func Create(jbov *md.JBOV) (bool, error) {
 		return false, err
 	}
 
-	// creates the metadata files
-	jsonb, err := jbov.Marshal()
-	if err != nil {
-		return false, err
-	}
+	// creates the metadata files, in every volume or in none
+	jbov.Touch()
+	mountPoints := []string{}
+	uniqids := make(map[string][]byte)
 	for _, vol := range jbov.Volumes {
-		filemode64, _ := strconv.ParseUint("744", 8, 32)
-		filemode := os.FileMode(filemode64)
-
-		err := ioutil.WriteFile(filepath.Join(vol.LastMountPoint, md.JBOV_FNAME), jsonb, filemode)
-		if err != nil {
-			return false, err
-		}
-
-		err = ioutil.WriteFile(filepath.Join(vol.LastMountPoint, md.UNIQID_FNAME), []byte(vol.Uniqid), filemode)
-		if err != nil {
-			return false, err
+		if fs, err := filesystemOf(vol.LastMountPoint); err == nil {
+			vol.Filesystem = fs.Fingerprint()
 		}
+		mountPoints = append(mountPoints, vol.LastMountPoint)
+		uniqids[filepath.Join(vol.LastMountPoint, md.UNIQID_FNAME)] = []byte(vol.Uniqid)
+	}
+	if err := writeMetadata(jbov, mountPoints, uniqids); err != nil {
+		return false, err
 	}
 	return true, nil
-}
\ No newline at end of file
+}
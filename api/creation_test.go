@@ -9,6 +9,12 @@ import (
 	"io/ioutil"
 )
 
+// test mount points are all temporary directories, in the same filesystem
+func TestMain(m *testing.M) {
+	AllowSameFilesystem = true
+	os.Exit(m.Run())
+}
+
 // CanCreate
 
 func TestCanCreateJBOV_ShouldFailWithInvalidJBOV(t *testing.T) {
@@ -119,8 +125,9 @@ func TestCreateJBOV_happyPath(t *testing.T) {
 	for _, volume := range jbov.Volumes {
 		jsonb, err := ioutil.ReadFile(filepath.Join(volume.LastMountPoint, md.JBOV_FNAME))
 		assert.NoError(t, err)
-		jbovInVol := md.JBOV{}.Unmarshall(&jsonb)
-		assert.Equal(t, jbov, jbovInVol)
+		jbovInVol, _, err := md.Load(jsonb)
+		assert.NoError(t, err)
+		assert.Equal(t, jbov, *jbovInVol)
 	}
 
 	//uniqid files
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"github.com/kuking/jbov/api/md"
+)
+
+// RuleVerdict tells how a rule weighs on a file: whether it matches the file and, if so, whether it governs it or it
+// is overridden by a rule with a higher priority. Unknown is set when the file is not found and whether it matches
+// depends on its size or age.
+type RuleVerdict struct {
+	Index   int
+	Rule    md.Rule
+	Matches bool
+	Governs bool
+	Unknown bool
+}
+
+// Explanation tells why a file is replicated the way it is: every rule considered, the requirement out of the rules
+// governing it, the volumes holding a replica (sorted) and what sync would do with it. Replicas in present volumes
+// which are not writable are listed apart, sync does not count them. Found is false when no volume holds the file, its
+// rules are still explained. Deleted is set when the file has a pending deletion, sync leaves those alone.
+type Explanation struct {
+	Path       string
+	Size       int64
+	Found      bool
+	Deleted    bool
+	Rules      []RuleVerdict
+	Ncopies    int
+	In         []string
+	Replicas   []string
+	Unwritable []string
+	Ops        []Op
+	Findings   []Finding
+}
+
+// Explain works out the rules, replicas and sync operations of a file, as found in the present volumes. The writable
+// volumes are scanned as sync would, their catalogs are not saved.
+func (handle *Handle) Explain(path string) (*Explanation, error) {
+	path, err := handle.RelativePath(path)
+	if err != nil {
+		return nil, err
+	}
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, err
+	}
+	explanation := Explanation{Path: path, Rules: []RuleVerdict{}, In: []string{}, Replicas: []string{}, Unwritable: []string{},
+		Ops: []Op{}, Findings: []Finding{}}
+	_, explanation.Deleted = handle.Jbov.Deleted[path]
+	var size, mtime int64
+	for _, cname := range handle.Present() {
+		if volCatalog, writable := catalogs[cname]; writable {
+			if entry, ok := volCatalog.Entries[path]; ok {
+				explanation.Replicas = append(explanation.Replicas, cname)
+				size, mtime = entry.Size, entry.Mtime
+			}
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path)))
+		if err == nil && !info.IsDir() {
+			explanation.Unwritable = append(explanation.Unwritable, cname)
+			if len(explanation.Replicas) == 0 {
+				size, mtime = info.Size(), info.ModTime().UnixNano()
+			}
+		}
+	}
+	explanation.Found = len(explanation.Replicas) > 0 || len(explanation.Unwritable) > 0
+	explanation.Size = size
+
+	known := true
+	for i, rule := range handle.Jbov.Rules {
+		verdict := RuleVerdict{Index: i, Rule: rule}
+		if explanation.Found {
+			verdict.Matches = rule.Matches(path, size, mtime)
+		} else {
+			var ruleKnown bool
+			verdict.Matches, ruleKnown = rule.MatchesPath(path)
+			verdict.Unknown = !ruleKnown
+			known = known && ruleKnown
+		}
+		explanation.Rules = append(explanation.Rules, verdict)
+	}
+	if !known {
+		return &explanation, nil
+	}
+	for _, g := range handle.Jbov.Governing(path, size, mtime) {
+		explanation.Rules[g].Governs = true
+	}
+	explanation.Ncopies, explanation.In = handle.Jbov.Requirement(path, size, mtime)
+	if len(explanation.Replicas) == 0 {
+		return &explanation, nil
+	}
+
+	plan := handle.planSync(catalogs)
+	for _, op := range plan.Ops {
+		if op.Path == path {
+			explanation.Ops = append(explanation.Ops, op)
+		}
+	}
+	for _, finding := range plan.Findings {
+		if finding.Path == path {
+			explanation.Findings = append(explanation.Findings, finding)
+		}
+	}
+	return &explanation, nil
+}
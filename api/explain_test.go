@@ -0,0 +1,112 @@
+package api
+
+import (
+	"testing"
+	"time"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "films/film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{
+		{Pattern: "*.txt", Ncopies: 3},
+		{Pattern: "films/", Ncopies: 3},
+		{Pattern: "*.mk4", Ncopies: 2, Priority: 1},
+	}
+
+	explanation, err := handle.Explain("films/film.mk4")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), explanation.Size)
+	assert.Equal(t, []RuleVerdict{
+		{0, handle.Jbov.Rules[0], false, false, false},
+		{1, handle.Jbov.Rules[1], true, false, false},
+		{2, handle.Jbov.Rules[2], true, true, false},
+	}, explanation.Rules)
+	assert.Equal(t, 2, explanation.Ncopies)
+	assert.Equal(t, []string{"vol1"}, explanation.Replicas)
+	assert.Equal(t, []Op{{OP_COPY, "films/film.mk4", "vol1", "vol2", 4, "", "2 copies required"}}, explanation.Ops)
+	assert.False(t, explanation.Deleted)
+}
+
+func TestExplain_notFoundStillExplainsTheRules(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{
+		{Pattern: "*.txt", Ncopies: 3},
+		{Pattern: "films/ size>1GiB", Ncopies: 3},
+		{Pattern: "*.mk4", Ncopies: 2},
+	}
+
+	explanation, err := handle.Explain("films/film.mk4")
+
+	assert.NoError(t, err)
+	assert.False(t, explanation.Found)
+	assert.Equal(t, []RuleVerdict{
+		{0, handle.Jbov.Rules[0], false, false, false},
+		{1, handle.Jbov.Rules[1], false, false, true},
+		{2, handle.Jbov.Rules[2], true, false, false},
+	}, explanation.Rules)
+	assert.Equal(t, 0, explanation.Ncopies)
+	assert.Empty(t, explanation.Replicas)
+	assert.Empty(t, explanation.Ops)
+}
+
+func TestExplain_notFoundWithRulesOnItsPathOnly(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "films/", Ncopies: 3}, {Pattern: "*.mk4", Ncopies: 2, Priority: 1}}
+
+	explanation, err := handle.Explain("films/film.mk4")
+
+	assert.NoError(t, err)
+	assert.False(t, explanation.Rules[0].Governs)
+	assert.True(t, explanation.Rules[1].Governs)
+	assert.Equal(t, 2, explanation.Ncopies)
+}
+
+func TestExplain_removedFile(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenTombstone(handle, "films/film.mk4", time.Now(), "vol3")
+
+	explanation, err := handle.Explain("films/film.mk4")
+
+	assert.NoError(t, err)
+	assert.False(t, explanation.Found)
+	assert.True(t, explanation.Deleted)
+}
+
+func TestExplain_replicasInVolumesNotWritable(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "films/film.mk4", "film", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Problems = []Problem{{PROBLEM_FOREIGN_METADATA, "vol2", "holds the metadata of another JBOV"}}
+
+	explanation, err := handle.Explain("films/film.mk4")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1"}, explanation.Replicas)
+	assert.Equal(t, []string{"vol2"}, explanation.Unwritable)
+}
+
+func TestExplain_doesNotSaveTheCatalogs(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenFileIn(&jbov, "films/film.mk4", "film", "vol1")
+
+	_, err := handle.Explain("films/film.mk4")
+
+	assert.NoError(t, err)
+	volCatalog, _ := handle.Catalog("vol1")
+	assert.NotContains(t, volCatalog.Entries, "films/film.mk4")
+}
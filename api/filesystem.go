@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// AllowSameFilesystem lets volumes share a filesystem, which gives a false sense of redundancy as all of them would
+// be lost together.
+var AllowSameFilesystem = false
+
+// freeSpace is replaceable so plans can be tested with volumes in the same filesystem.
+var freeSpace = freeSpaceOf
+
+// Filesystem identifies the filesystem holding a volume, by its device id and its filesystem id (statfs).
+type Filesystem struct {
+	Dev  uint64
+	Fsid string
+}
+
+func newFilesystem(dev uint64, fsid string) *Filesystem {
+	return &Filesystem{Dev: dev, Fsid: fsid}
+}
+
+// Fingerprint is recorded in the volume metadata. Device ids are assigned when mounting, so the filesystem id is
+// preferred when the filesystem provides one.
+func (fs *Filesystem) Fingerprint() string {
+	if fs.Fsid != "0000000000000000" {
+		return "fsid:" + fs.Fsid
+	}
+	return fmt.Sprintf("dev:%x", fs.Dev)
+}
+
+func (fs *Filesystem) isSameAs(other *Filesystem) bool {
+	return fs.Dev == other.Dev || fs.Fingerprint() == other.Fingerprint()
+}
+
+// colocatedVolumes returns the pairs of volumes sharing a filesystem, given the mount points by cname. Volumes whose
+// filesystem can not be identified are ignored.
+func colocatedVolumes(mountPoints map[string]string) [][2]string {
+	cnames := []string{}
+	filesystems := make(map[string]*Filesystem)
+	for cname, mountPoint := range mountPoints {
+		if fs, err := filesystemOf(mountPoint); err == nil {
+			cnames = append(cnames, cname)
+			filesystems[cname] = fs
+		}
+	}
+	sort.Strings(cnames)
+	pairs := [][2]string{}
+	for i, cname := range cnames {
+		for _, other := range cnames[i+1:] {
+			if filesystems[cname].isSameAs(filesystems[other]) {
+				pairs = append(pairs, [2]string{cname, other})
+			}
+		}
+	}
+	return pairs
+}
+
+func colocatedError(pairs [][2]string) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Volumes \"%s\" and \"%s\" are in the same filesystem, they would not be redundant copies", pairs[0][0], pairs[0][1]))
+}
+
+// checkFilesystems warns about present volumes sharing a filesystem between them, or with the filesystem last recorded
+// for a volume not present.
+func (handle *Handle) checkFilesystems() []Problem {
+	warnings := []Problem{}
+	mountPoints := make(map[string]string)
+	for _, cname := range handle.Present() {
+		mountPoints[cname] = handle.Volumes[cname].MountPoint
+	}
+	for _, pair := range colocatedVolumes(mountPoints) {
+		warnings = append(warnings, Problem{PROBLEM_SAME_FILESYSTEM, pair[0], fmt.Sprintf("is in the same filesystem as volume \"%s\"", pair[1])})
+	}
+	for _, cname := range handle.Present() {
+		fingerprint := handle.Jbov.Volumes[cname].Filesystem
+		for other, status := range handle.Volumes {
+			if status.State != VOLUME_PRESENT && fingerprint != "" && handle.Jbov.Volumes[other].Filesystem == fingerprint {
+				warnings = append(warnings, Problem{PROBLEM_SAME_FILESYSTEM, cname, fmt.Sprintf("is in the filesystem last seen holding volume \"%s\"", other)})
+			}
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].Cname < warnings[j].Cname })
+	return warnings
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func filesystemOf(path string) (*Filesystem, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return nil, err
+	}
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return nil, err
+	}
+	return newFilesystem(uint64(stat.Dev), fmt.Sprintf("%08x%08x", uint32(statfs.Fsid.X__val[0]), uint32(statfs.Fsid.X__val[1]))), nil
+}
+
+func freeSpaceOf(path string) (int64, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return 0, err
+	}
+	return int64(statfs.Bavail) * int64(statfs.Bsize), nil
+}
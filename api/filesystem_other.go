@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package api
+
+import (
+	"errors"
+)
+
+func filesystemOf(path string) (*Filesystem, error) {
+	return nil, errors.New("Filesystem identification is not supported in this platform")
+}
+
+func freeSpaceOf(path string) (int64, error) {
+	return 0, errors.New("Free space is not available in this platform")
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"strings"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanCreate_refusesVolumesInTheSameFilesystem(t *testing.T) {
+	jbov := givenValidJBOV()
+	givenMountPointsExist(&jbov)
+	defer cleanupMountPoints(&jbov)
+	defer givenSameFilesystemAllowed(false)()
+
+	ok, err := CanCreate(&jbov)
+
+	assert.False(t, ok)
+	assert.EqualError(t, err, "Volumes \"vol1\" and \"vol2\" are in the same filesystem, they would not be redundant copies")
+}
+
+func TestCreate_recordsVolumesFilesystem(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	for _, vol := range handle.Jbov.Volumes {
+		assert.True(t, strings.HasPrefix(vol.Filesystem, "fsid:") || strings.HasPrefix(vol.Filesystem, "dev:"))
+	}
+}
+
+func TestOpen_warnsAboutVolumesInTheSameFilesystem(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Len(t, handle.Warnings, 1)
+	assert.Equal(t, PROBLEM_SAME_FILESYSTEM, handle.Warnings[0].Kind)
+	assert.Equal(t, "volume \"vol1\": is in the same filesystem as volume \"vol2\"", handle.Warnings[0].String())
+	assert.Equal(t, []string{"vol1", "vol2"}, handle.Writable())
+}
+
+func TestOpen_warnsWhenVolumeIsInTheFilesystemOfAMissingOne(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	os.RemoveAll(jbov.Volumes["vol2"].LastMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Len(t, handle.Warnings, 1)
+	assert.Equal(t, "volume \"vol1\": is in the filesystem last seen holding volume \"vol2\"", handle.Warnings[0].String())
+}
+
+// utility
+
+func givenSameFilesystemAllowed(allowed bool) func() {
+	previous := AllowSameFilesystem
+	AllowSameFilesystem = allowed
+	return func() { AllowSameFilesystem = previous }
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/kuking/jbov/api/catalog"
+)
+
+// RelativePath turns a path into its jbov path, relative to the volumes root and slash separated. Absolute paths must
+// be inside one of the present volumes.
+func (handle *Handle) RelativePath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		for _, cname := range handle.Present() {
+			rel, err := filepath.Rel(handle.Volumes[cname].MountPoint, path)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				path = rel
+				break
+			}
+		}
+		if filepath.IsAbs(path) {
+			return "", errors.New(fmt.Sprintf("Path \"%s\" is not in any of JBOV \"%s\" volumes", path, handle.Jbov.Cname))
+		}
+	}
+	path = filepath.Clean(path)
+	if path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("Path \"%s\" is not a file in JBOV \"%s\"", path, handle.Jbov.Cname))
+	}
+	return filepath.ToSlash(path), nil
+}
+
+// ReplicaHashes returns the content hash of a file in every writable volume holding a copy of it, by volume cname.
+// Hashes are cached in the volume catalogs, so files are only read again when they change.
+func (handle *Handle) ReplicaHashes(path string) (map[string]string, error) {
+	path, err := handle.RelativePath(path)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	for _, cname := range handle.Writable() {
+		if _, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path))); os.IsNotExist(err) {
+			continue
+		}
+		sum, err := handle.replicaHash(cname, path)
+		if err != nil {
+			return nil, err
+		}
+		hashes[cname] = sum
+	}
+	return hashes, nil
+}
+
+// replicaHash returns the content hash of a file in a volume, given its jbov path; through the volume catalog.
+func (handle *Handle) replicaHash(cname string, path string) (string, error) {
+	mountPoint := handle.Volumes[cname].MountPoint
+	volCatalog, err := handle.Catalog(cname)
+	if err != nil {
+		return "", err
+	}
+	sum, cached, err := volCatalog.Hash(mountPoint, path, handle.Jbov.Hashing())
+	if err != nil {
+		return "", err
+	}
+	if !cached {
+		if err := handle.saveCatalogs(map[string]*catalog.Catalog{cname: volCatalog}); err != nil {
+			return "", err
+		}
+	}
+	return sum, nil
+}
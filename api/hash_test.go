@@ -0,0 +1,60 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaHashes_ofEveryVolumeHoldingTheFile(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	for _, vol := range jbov.Volumes {
+		ioutil.WriteFile(filepath.Join(vol.LastMountPoint, "file.txt"), []byte("hello"), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "only1.txt"), []byte("hello"), 0644)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	hashes, err := handle.ReplicaHashes("file.txt")
+
+	assert.NoError(t, err)
+	expected := "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	assert.Equal(t, map[string]string{"vol1": expected, "vol2": expected}, hashes)
+	saved, _ := handle.Catalog("vol2")
+	assert.Equal(t, expected, saved.Entries["file.txt"].Hash)
+
+	hashes, err = handle.ReplicaHashes(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "only1.txt"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"vol1": expected}, hashes)
+}
+
+func TestReplicaHashes_usesTheJBOVAlgorithm(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.HashAlgorithm = "xxhash"
+	givenMountPointsExist(&jbov)
+	defer cleanupMountPoints(&jbov)
+	Create(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "file.txt"), []byte("hello"), 0644)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	hashes, err := handle.ReplicaHashes("file.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "xxhash:26c7827d889f6da3", hashes["vol1"])
+}
+
+func TestRelativePath_refusesPathsOutOfTheVolumes(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	_, err := handle.RelativePath("/somewhere/else")
+	assert.Error(t, err)
+	_, err = handle.RelativePath("../file")
+	assert.Error(t, err)
+	path, err := handle.RelativePath("dir//file")
+	assert.NoError(t, err)
+	assert.Equal(t, "dir/file", path)
+}
@@ -0,0 +1,83 @@
+package hashing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+)
+
+const SHA256 = "sha256"
+const BLAKE3 = "blake3"
+const XXHASH = "xxhash"
+
+// DEFAULT is the algorithm used by JBOVs which do not select one.
+const DEFAULT = SHA256
+
+// ALGORITHMS lists the supported algorithms. xxhash is not cryptographic, it detects corruption but not tampering.
+var ALGORITHMS = []string{SHA256, BLAKE3, XXHASH}
+
+func IsSupported(algorithm string) bool {
+	for _, supported := range ALGORITHMS {
+		if algorithm == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func New(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(32, nil), nil
+	case XXHASH:
+		return xxhash.New(), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Hash algorithm \"%s\" is not supported, use one of %v", algorithm, ALGORITHMS))
+}
+
+// Sum hashes everything read from the reader, the result is prefixed with the algorithm, i.e. "sha256:<hex>".
+func Sum(algorithm string, reader io.Reader) (string, error) {
+	h, err := New(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", err
+	}
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// File hashes the content of a file, symlinks are hashed by their target path rather than followed.
+func File(algorithm string, path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return Sum(algorithm, strings.NewReader(target))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return Sum(algorithm, f)
+}
+
+// AlgorithmOf returns the algorithm a hash was computed with.
+func AlgorithmOf(sum string) string {
+	return strings.SplitN(sum, ":", 2)[0]
+}
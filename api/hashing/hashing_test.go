@@ -0,0 +1,47 @@
+package hashing
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSum_everyAlgorithm(t *testing.T) {
+	expected := map[string]string{
+		SHA256: "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		BLAKE3: "blake3:ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f",
+		XXHASH: "xxhash:26c7827d889f6da3",
+	}
+	for _, algorithm := range ALGORITHMS {
+		sum, err := Sum(algorithm, strings.NewReader("hello"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, expected[algorithm], sum)
+		assert.Equal(t, algorithm, AlgorithmOf(sum))
+	}
+}
+
+func TestSum_unsupportedAlgorithm(t *testing.T) {
+	_, err := Sum("md5", strings.NewReader("hello"))
+
+	assert.EqualError(t, err, "Hash algorithm \"md5\" is not supported, use one of [sha256 blake3 xxhash]")
+	assert.False(t, IsSupported("md5"))
+}
+
+func TestFile_hashesSymlinksByTarget(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0644)
+	os.Symlink("file", filepath.Join(dir, "link"))
+
+	file, err := File(SHA256, filepath.Join(dir, "file"))
+	assert.NoError(t, err)
+	link, err := File(SHA256, filepath.Join(dir, "link"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", file)
+	assert.NotEqual(t, file, link)
+}
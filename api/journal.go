@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/hashing"
+)
+
+// JOURNAL_FNAME is the write-ahead journal of the plan being applied, kept in every writable volume while applying it;
+// it is left behind only when the application is interrupted (a crash, a reboot) or fails (an unplugged volume).
+const JOURNAL_FNAME = ".jbov.journal"
+
+const (
+	JOURNAL_BEGIN = "begin"
+	JOURNAL_DONE  = "done"
+)
+
+// journalRecord is a line of the journal: the plan being applied (the first line) or an operation of it, by index,
+// begun or done.
+type journalRecord struct {
+	Ts    int64 `json:"ts"`
+	Plan  *Plan `json:"plan,omitempty"`
+	Op    int `json:"op"`
+	State string `json:"state,omitempty"`
+}
+
+type journal struct {
+	paths []string
+	files []*os.File
+}
+
+// Interruption is a plan whose application was interrupted, as recorded in the journal: the operations done, and the
+// one in progress when interrupted (-1 when none).
+type Interruption struct {
+	Plan  *Plan
+	Done  map[int]bool
+	Begun int
+}
+
+// Pending returns the number of operations not done yet.
+func (interruption *Interruption) Pending() int {
+	return len(interruption.Plan.Ops) - len(interruption.Done)
+}
+
+// startJournal writes a new journal, for the given plan and operations already done, in every writable volume.
+func (handle *Handle) startJournal(plan *Plan, done map[int]bool) (*journal, error) {
+	j := journal{}
+	for _, cname := range handle.Writable() {
+		path := filepath.Join(handle.Volumes[cname].MountPoint, JOURNAL_FNAME)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+		if err != nil {
+			j.finish()
+			return nil, err
+		}
+		j.paths = append(j.paths, path)
+		j.files = append(j.files, f)
+	}
+	if err := j.record(journalRecord{Plan: plan}); err != nil {
+		j.finish()
+		return nil, err
+	}
+	for i := range plan.Ops {
+		if done[i] {
+			if err := j.record(journalRecord{Op: i, State: JOURNAL_DONE}); err != nil {
+				j.finish()
+				return nil, err
+			}
+		}
+	}
+	return &j, nil
+}
+
+// record appends a record to the journal in every volume, it is on disk when it returns.
+func (j *journal) record(record journalRecord) error {
+	record.Ts = time.Now().Unix()
+	jsonb, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	jsonb = append(jsonb, '\n')
+	for _, f := range j.files {
+		if _, err := f.Write(jsonb); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish closes and removes the journal, the plan is no longer being applied.
+func (j *journal) finish() {
+	for i, f := range j.files {
+		f.Close()
+		os.Remove(j.paths[i])
+	}
+}
+
+// Interruption returns the plan whose application was interrupted, nil when there is none. The journals of every
+// writable volume are read and the most complete one used, as the interruption might have happened while writing them.
+func (handle *Handle) Interruption() (*Interruption, error) {
+	var found *Interruption
+	records := 0
+	for _, cname := range handle.Writable() {
+		interruption, count, err := readJournal(filepath.Join(handle.Volumes[cname].MountPoint, JOURNAL_FNAME))
+		if err != nil {
+			return nil, err
+		}
+		if interruption != nil && count > records && interruption.Plan.Jbov == handle.Jbov.Uniqid {
+			found, records = interruption, count
+		}
+	}
+	return found, nil
+}
+
+// readJournal reads a journal and the number of records in it, up to the first incomplete one.
+func readJournal(path string) (*Interruption, int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var interruption *Interruption
+	count := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		record := journalRecord{}
+		if err != nil || json.Unmarshal(line, &record) != nil {
+			break
+		}
+		if count == 0 {
+			if record.Plan == nil {
+				return nil, 0, nil
+			}
+			interruption = &Interruption{Plan: record.Plan, Done: make(map[int]bool), Begun: -1}
+		} else if record.Op >= 0 && record.Op < len(interruption.Plan.Ops) {
+			switch record.State {
+			case JOURNAL_BEGIN:
+				interruption.Begun = record.Op
+			case JOURNAL_DONE:
+				interruption.Done[record.Op] = true
+				if interruption.Begun == record.Op {
+					interruption.Begun = -1
+				}
+			}
+		}
+		count++
+	}
+	return interruption, count, nil
+}
+
+// recoverJournal cleans up after an interrupted plan: the temporary files left are removed and the operation in
+// progress, when it got far enough, is rolled forward. The rest of the plan is left in the journal, to be resumed; a
+// warning tells about it.
+func (handle *Handle) recoverJournal() {
+	interruption, err := handle.Interruption()
+	if err != nil || interruption == nil {
+		return
+	}
+	for _, cname := range handle.Writable() {
+		removeTemporaryFiles(handle.Volumes[cname].MountPoint)
+	}
+	if interruption.Begun >= 0 && handle.rollForward(interruption.Plan.Ops[interruption.Begun]) {
+		interruption.Done[interruption.Begun] = true
+		if j, err := handle.startJournal(interruption.Plan, interruption.Done); err == nil {
+			j.close()
+		}
+	}
+	if interruption.Pending() == 0 {
+		handle.removeJournals()
+		return
+	}
+	command := interruption.Plan.Command
+	handle.Warnings = append(handle.Warnings, Problem{PROBLEM_INTERRUPTED, handle.Writable()[0],
+		fmt.Sprintf("%s was interrupted with %d of %d operations pending, resume it with: jbov %s --resume",
+			command, interruption.Pending(), len(interruption.Plan.Ops), command)})
+}
+
+func (handle *Handle) removeJournals() {
+	for _, cname := range handle.Writable() {
+		os.Remove(filepath.Join(handle.Volumes[cname].MountPoint, JOURNAL_FNAME))
+	}
+}
+
+func (j *journal) close() {
+	for _, f := range j.files {
+		f.Close()
+	}
+}
+
+// rollForward completes an operation interrupted once its outcome was in place, it tells whether the operation is
+// done. Copies are renamed into place only once verified, so a file in the target means it was copied.
+func (handle *Handle) rollForward(op Op) bool {
+	target := ""
+	if status, ok := handle.Volumes[op.Target]; ok {
+		target = filepath.Join(status.MountPoint, filepath.FromSlash(op.Path))
+	}
+	switch op.Kind {
+	case OP_COPY:
+		_, err := os.Lstat(target)
+		return err == nil
+	case OP_MOVE:
+		copied, err := hashing.File(handle.Jbov.Hashing(), target)
+		if err != nil {
+			return false
+		}
+		source := filepath.Join(handle.Volumes[op.Source].MountPoint, filepath.FromSlash(op.Path))
+		if _, err := os.Lstat(source); os.IsNotExist(err) {
+			return true
+		}
+		if sum, err := hashing.File(handle.Jbov.Hashing(), source); err != nil || sum != copied {
+			return false
+		}
+		return os.Remove(source) == nil
+	case OP_DELETE:
+		_, err := os.Lstat(target)
+		return os.IsNotExist(err)
+	case OP_REPAIR:
+		sum, err := hashing.File(handle.Jbov.Hashing(), target)
+		return err == nil && sum == op.Hash
+	case OP_REMOVE_VOLUME:
+		_, ok := handle.Jbov.Volumes[op.Target]
+		return !ok
+	}
+	return false
+}
+
+// removeTemporaryFiles removes the temporary files left in a volume by interrupted copies.
+func removeTemporaryFiles(mountPoint string) {
+	filepath.Walk(mountPoint, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if filepath.Dir(path) == mountPoint && strings.HasPrefix(info.Name(), catalog.JBOV_PREFIX) && info.IsDir() {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasPrefix(info.Name(), catalog.TMP_PREFIX) {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// Resume carries on applying the interrupted plan, skipping the operations done; it returns the plan and the number of
+// operations done now. The plan is not checked against the catalogs, as its own operations changed them, every
+// operation checks the files it changes instead: copies are verified and never replace a file.
+func (handle *Handle) Resume(command string) (*Plan, int, error) {
+	interruption, err := handle.Interruption()
+	if err != nil {
+		return nil, 0, err
+	}
+	if interruption == nil {
+		return nil, 0, errors.New("There is no interrupted operation to resume")
+	}
+	plan := interruption.Plan
+	if command != "" && plan.Command != command {
+		return nil, 0, errors.New(fmt.Sprintf("The interrupted operation is a %s, not a %s", plan.Command, command))
+	}
+	lock, err := handle.Lock(plan.Command)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer lock.Release()
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, 0, err
+	}
+	done, err := handle.run(plan, interruption.Done, catalogs)
+	return plan, done, err
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply_removesTheJournalOnceFinished(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+	plan, _ := handle.PlanSync()
+
+	handle.Apply(plan)
+
+	for _, vol := range jbov.Volumes {
+		_, err := os.Stat(filepath.Join(vol.LastMountPoint, JOURNAL_FNAME))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestOpen_recoversAnInterruptedSync(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "a.mk4", "aaa", "vol1")
+	givenFileIn(&jbov, "b.mk4", "bbb", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+	plan, _ := handle.PlanSync()
+	givenInterruptedWhile(t, handle, plan, 0)
+	givenFileIn(&jbov, "a.mk4", "aaa", "vol2")
+	givenFileIn(&jbov, "dir/.jbov.tmp-123", "half", "vol2")
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, PROBLEM_INTERRUPTED, handle.Warnings[len(handle.Warnings)-1].Kind)
+	assert.Contains(t, handle.Warnings[len(handle.Warnings)-1].Detail, "sync was interrupted with 1 of 2 operations pending, resume it with: jbov sync --resume")
+	leftovers, _ := filepath.Glob(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "dir", ".jbov.tmp-*"))
+	assert.Empty(t, leftovers)
+	interruption, _ := handle.Interruption()
+	assert.Equal(t, map[int]bool{0: true}, interruption.Done)
+
+	resumed, done, err := handle.Resume("sync")
+
+	assert.NoError(t, err)
+	assert.Equal(t, plan.Ops, resumed.Ops)
+	assert.Equal(t, 1, done)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes[plan.Ops[1].Target].LastMountPoint, "b.mk4"))
+	assert.Equal(t, "bbb", string(content))
+	interruption, _ = handle.Interruption()
+	assert.Nil(t, interruption)
+}
+
+func TestApply_keepsTheJournalOfAFailedPlanToResumeIt(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "a.mk4", "aaa", "vol1")
+	givenFileIn(&jbov, "b.mk4", "bbb", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+	plan, _ := handle.PlanSync()
+	restore := givenRenameFailsIn(jbov.Volumes[plan.Ops[1].Target].LastMountPoint)
+
+	done, err := handle.Apply(plan)
+	restore()
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, done)
+	handle, _ = Open(jbov.Volumes["vol1"].LastMountPoint)
+	interruption, _ := handle.Interruption()
+	assert.Equal(t, map[int]bool{0: true}, interruption.Done)
+	_, done, err = handle.Resume("sync")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, done)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes[plan.Ops[1].Target].LastMountPoint, "b.mk4"))
+	assert.Equal(t, "bbb", string(content))
+}
+
+func TestOpen_rollsForwardAnInterruptedMove(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "a.bin", "aaa", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	plan := handle.newPlan("rebalance", nil)
+	plan.add(Op{OP_MOVE, "a.bin", "vol1", "vol2", 3, "", "rebalance"})
+	givenInterruptedWhile(t, handle, plan, 0)
+
+	handle, _ = Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	_, err := os.Stat(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "a.bin"))
+	assert.True(t, os.IsNotExist(err))
+	interruption, _ := handle.Interruption()
+	assert.Nil(t, interruption)
+}
+
+func TestResume_withoutInterruption(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	_, _, err := handle.Resume("sync")
+
+	assert.EqualError(t, err, "There is no interrupted operation to resume")
+}
+
+// utility
+
+// givenInterruptedWhile leaves the journal as if the plan was interrupted while applying the given operation.
+func givenInterruptedWhile(t *testing.T, handle *Handle, plan *Plan, op int) {
+	j, err := handle.startJournal(plan, map[int]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < op; i++ {
+		j.record(journalRecord{Op: i, State: JOURNAL_DONE})
+	}
+	j.record(journalRecord{Op: op, State: JOURNAL_BEGIN})
+	j.close()
+}
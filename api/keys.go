@@ -0,0 +1,118 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/kuking/jbov/api/md"
+)
+
+// KeysDir holds the JBOV signing keys, one per JBOV. It is kept out of the volumes so a volume content alone can not
+// be used to forge its metadata. It is JBOV_KEYS when set, otherwise a directory in the user configuration directory;
+// empty when this host has none, the keys can not be used until it is given.
+var KeysDir = defaultKeysDir()
+
+const KEY_SIZE = 32
+
+func defaultKeysDir() string {
+	if dir := os.Getenv("JBOV_KEYS"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "jbov", "keys")
+}
+
+func keyPath(jbovUniqid string) (string, error) {
+	if KeysDir == "" {
+		return "", errors.New("the signing keys directory is unknown in this host, give it with --keys-dir or JBOV_KEYS")
+	}
+	return filepath.Join(KeysDir, strings.TrimPrefix(jbovUniqid, "JBOV:")+".key"), nil
+}
+
+// keyUnavailable tells why the key of the given JBOV can not be used in this host.
+func keyUnavailable(jbovUniqid string) string {
+	path, err := keyPath(jbovUniqid)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("its key is not available in this host (%s)", path)
+}
+
+// GenerateKey creates the signing key for the given JBOV, failing if there is one already.
+func GenerateKey(jbovUniqid string) ([]byte, error) {
+	path, err := keyPath(jbovUniqid)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(KeysDir, 0700); err != nil {
+		return nil, err
+	}
+	key := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	return key, f.Sync()
+}
+
+// RemoveKey deletes the signing key of the given JBOV, i.e. when the JBOV it was generated for could not be created.
+func RemoveKey(jbovUniqid string) error {
+	path, err := keyPath(jbovUniqid)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ReadKey returns the signing key of the given JBOV, or nil if this host does not have it.
+func ReadKey(jbovUniqid string) ([]byte, error) {
+	path, err := keyPath(jbovUniqid)
+	if err != nil {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(content)))
+}
+
+// SignatureError is returned when the metadata held by a volume is not signed by the JBOV key.
+type SignatureError struct {
+	Cname      string
+	MountPoint string
+}
+
+func (err *SignatureError) Error() string {
+	return fmt.Sprintf("JBOV metadata in volume \"%s\" (%s) fails signature verification, it might have been tampered", err.Cname, err.MountPoint)
+}
+
+// signWith signs the metadata if there is a key for it; signed metadata can not be written without its key, as the
+// signature would be lost.
+func signWith(jbov *md.JBOV, key []byte) error {
+	if key != nil {
+		return jbov.Sign(key)
+	}
+	if jbov.Signature != "" {
+		return errors.New(fmt.Sprintf("JBOV \"%s\" metadata is signed but %s", jbov.Cname, keyUnavailable(jbov.Uniqid)))
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen_verifiesSignedMetadata(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, handle.Jbov.Signature)
+	assert.NoError(t, handle.Save())
+	_, err = Open(jbov.Volumes["vol2"].LastMountPoint)
+	assert.NoError(t, err)
+}
+
+func TestOpen_failsWhenAVolumeMetadataHasBeenTampered(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	tampered := readMetadataCopy(jbov.Volumes["vol2"].LastMountPoint)
+	tampered.Rules = []md.Rule{{Pattern: "*", Ncopies: 1}}
+	tampered.Touch()
+	givenMetadataCopy(&tampered, jbov.Volumes["vol2"].LastMountPoint)
+
+	_, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.EqualError(t, err, "JBOV metadata in volume \"vol2\" ("+jbov.Volumes["vol2"].LastMountPoint+") fails signature verification, it might have been tampered")
+	_, err = Open(jbov.Volumes["vol2"].LastMountPoint)
+	assert.IsType(t, &SignatureError{}, err)
+	assert.Equal(t, "vol2", err.(*SignatureError).Cname)
+}
+
+func TestOpen_failsWhenSignatureIsStripped(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	stripped := readMetadataCopy(jbov.Volumes["vol1"].LastMountPoint)
+	stripped.Signature = ""
+	givenMetadataCopy(&stripped, jbov.Volumes["vol1"].LastMountPoint)
+
+	_, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.IsType(t, &SignatureError{}, err)
+}
+
+func TestOpen_signedMetadataInAnOlderSchemaVersion(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	key, _ := ReadKey(jbov.Uniqid)
+	for _, vol := range jbov.Volumes {
+		givenSignedInSchemaVersion(t, vol.LastMountPoint, md.SCHEMA_VERSION-1, key)
+	}
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, md.SCHEMA_VERSION, handle.Jbov.SchemaVersion)
+	for _, vol := range jbov.Volumes {
+		raw, _ := ioutil.ReadFile(filepath.Join(vol.LastMountPoint, md.JBOV_FNAME))
+		assert.True(t, md.VerifyRawSignature(raw, key))
+		assert.Contains(t, string(raw), fmt.Sprintf("\"schema-version\": %d", md.SCHEMA_VERSION))
+	}
+	_, err = Open(jbov.Volumes["vol2"].LastMountPoint)
+	assert.NoError(t, err)
+}
+
+func TestSave_refusesToWriteSignedMetadataWithoutItsKey(t *testing.T) {
+	restore := givenKeysDir()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	restore()
+	defer givenKeysDir()()
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.NoError(t, err)
+
+	err = handle.Save()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "metadata is signed but its key is not available in this host")
+}
+
+func TestOpen_warnsWhenTheSignatureCanNotBeVerified(t *testing.T) {
+	restore := givenKeysDir()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	restore()
+	defer givenKeysDir()()
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Contains(t, handle.Warnings, Problem{PROBLEM_UNVERIFIED_SIGNATURE, "",
+		"metadata is signed but its signature is not verified, its key is not available in this host (" + filepath.Join(KeysDir, jbov.Uniqid[len("JBOV:"):]+".key") + ")"})
+}
+
+func TestOpen_doesNotWarnWhenTheSignatureIsVerified(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenCreatedSignedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	for _, warning := range handle.Warnings {
+		assert.NotEqual(t, PROBLEM_UNVERIFIED_SIGNATURE, warning.Kind)
+	}
+}
+
+func TestGenerateKey_failsWhenTheKeysDirIsUnknown(t *testing.T) {
+	keysDir := KeysDir
+	KeysDir = ""
+	defer func() { KeysDir = keysDir }()
+
+	_, err := GenerateKey(givenValidJBOV().Uniqid)
+
+	assert.EqualError(t, err, "the signing keys directory is unknown in this host, give it with --keys-dir or JBOV_KEYS")
+}
+
+func TestDefaultKeysDir_isJbovKeysWhenSet(t *testing.T) {
+	jbovKeys, set := os.LookupEnv("JBOV_KEYS")
+	os.Setenv("JBOV_KEYS", "/somewhere/keys")
+	defer func() {
+		if set {
+			os.Setenv("JBOV_KEYS", jbovKeys)
+		} else {
+			os.Unsetenv("JBOV_KEYS")
+		}
+	}()
+
+	assert.Equal(t, "/somewhere/keys", defaultKeysDir())
+}
+
+func TestRemoveKey(t *testing.T) {
+	defer givenKeysDir()()
+	jbov := givenValidJBOV()
+	GenerateKey(jbov.Uniqid)
+
+	assert.NoError(t, RemoveKey(jbov.Uniqid))
+
+	key, err := ReadKey(jbov.Uniqid)
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+// utility
+
+func givenKeysDir() func() {
+	keysDir := KeysDir
+	KeysDir, _ = ioutil.TempDir(os.TempDir(), "")
+	dir := KeysDir
+	return func() {
+		os.RemoveAll(dir)
+		KeysDir = keysDir
+	}
+}
+
+// givenSignedInSchemaVersion rewrites the metadata held in a volume as signed in an older schema version.
+func givenSignedInSchemaVersion(t *testing.T, mountPoint string, version int, key []byte) {
+	path := filepath.Join(mountPoint, md.JBOV_FNAME)
+	raw, _ := ioutil.ReadFile(path)
+	var document map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+	document["schema-version"] = version
+	unsigned, _ := json.Marshal(document)
+	document["signature"], _ = md.Signature(unsigned, key)
+	signed, _ := json.MarshalIndent(document, "", "  ")
+	if err := ioutil.WriteFile(path, signed, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func givenCreatedSignedJBOV(t *testing.T) md.JBOV {
+	jbov := givenValidJBOV()
+	givenMountPointsExist(&jbov)
+	if _, err := GenerateKey(jbov.Uniqid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(&jbov); err != nil {
+		t.Fatal(err)
+	}
+	return jbov
+}
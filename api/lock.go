@@ -0,0 +1,303 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"github.com/kuking/jbov/api/md"
+)
+
+// LeaseDuration is how long a lock taken by another host is honoured without being renewed. Locks taken in this host
+// are honoured for as long as the process holding them is alive. Locks are renewed every third of it while held.
+var LeaseDuration = time.Hour
+
+const LOCK_GRACE = 10 * time.Second
+
+type LockInfo struct {
+	Token     string `json:"token"`
+	Host      string `json:"host"`
+	Pid       int `json:"pid"`
+	Started   int64 `json:"started"`
+	Expires   int64 `json:"expires"`
+	Operation string `json:"operation"`
+}
+
+// Lock is held on the volumes locked, paths holds their lock file path by cname.
+type Lock struct {
+	Info   LockInfo
+	handle *Handle
+	paths  map[string]string
+	mutex  sync.Mutex
+	stop   chan struct{}
+}
+
+// IsStale tells if the lock holder is gone: a dead process in this host or an expired lease for other hosts.
+func (info *LockInfo) IsStale() bool {
+	host, _ := os.Hostname()
+	if info.Host == host {
+		return !isProcessAlive(info.Pid)
+	}
+	return time.Now().Unix() > info.Expires
+}
+
+func (info *LockInfo) String() string {
+	return fmt.Sprintf("%s (pid %d on %s since %s)", info.Operation, info.Pid, info.Host, time.Unix(info.Started, 0).Format(time.RFC3339))
+}
+
+func isProcessAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// Lock acquires the lock file in every present volume for the given operation, failing if any of them is held by a
+// live holder. Stale locks are taken over. Once locked, the metadata is reconciled again with the copies in the volumes,
+// so changes made by others since it was read are not overwritten; and the lease is renewed until released.
+func (handle *Handle) Lock(operation string) (*Lock, error) {
+	host, _ := os.Hostname()
+	now := time.Now()
+	lock := Lock{
+		Info: LockInfo{
+			Token:     md.GenerateLockToken(),
+			Host:      host,
+			Pid:       os.Getpid(),
+			Started:   now.Unix(),
+			Expires:   now.Add(LeaseDuration).Unix(),
+			Operation: operation,
+		},
+		handle: handle,
+		paths:  make(map[string]string),
+	}
+	jsonb, err := json.MarshalIndent(lock.Info, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cname := range handle.Present() {
+		path := filepath.Join(handle.Volumes[cname].MountPoint, md.LOCK_FNAME)
+		err := createLockFile(path, jsonb)
+		if os.IsExist(err) && isStaleLockFile(path) {
+			err = takeOver(path, jsonb, lock.Info.Token)
+		}
+		if os.IsExist(err) {
+			lock.Release()
+			if info, _ := readLockFile(path); info != nil {
+				return nil, errors.New(fmt.Sprintf("JBOV is locked in volume \"%s\" by %s", cname, info))
+			}
+			return nil, errors.New(fmt.Sprintf("JBOV is locked in volume \"%s\"", cname))
+		}
+		if err != nil {
+			lock.Release()
+			return nil, err
+		}
+		lock.paths[cname] = path
+	}
+	if err := handle.refresh(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	lock.stop = make(chan struct{})
+	go lock.renewEvery(LeaseDuration/3, lock.stop)
+	handle.lock = &lock
+	return &lock, nil
+}
+
+// renewEvery renews the lock lease periodically, until stopped when the lock is released.
+func (lock *Lock) renewEvery(period time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lock.Renew()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Renew extends the lock lease. It is renewed periodically while held, there is no need to call it.
+func (lock *Lock) Renew() error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	lock.Info.Expires = time.Now().Add(LeaseDuration).Unix()
+	jsonb, err := json.MarshalIndent(lock.Info, "", "    ")
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for cname, path := range lock.paths {
+		if info, _ := readLockFile(path); info == nil || info.Token != lock.Info.Token {
+			if firstErr == nil {
+				firstErr = errors.New(fmt.Sprintf("JBOV lock in volume \"%s\" has been taken over", cname))
+			}
+			continue
+		}
+		if err := writeLockFile(path, jsonb); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Release removes the lock files still held by this lock, and stops renewing it.
+func (lock *Lock) Release() error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.stop != nil {
+		close(lock.stop)
+		lock.stop = nil
+	}
+	var firstErr error
+	for _, path := range lock.paths {
+		if info, _ := readLockFile(path); info != nil && info.Token == lock.Info.Token {
+			if err := os.Remove(path); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	lock.paths = make(map[string]string)
+	if lock.handle.lock == lock {
+		lock.handle.lock = nil
+	}
+	return firstErr
+}
+
+// Locks returns the lock found in every present volume, by volume cname.
+func (handle *Handle) Locks() map[string]*LockInfo {
+	locks := make(map[string]*LockInfo)
+	for _, cname := range handle.Present() {
+		if info, err := readLockFile(filepath.Join(handle.Volumes[cname].MountPoint, md.LOCK_FNAME)); err == nil {
+			locks[cname] = info
+		}
+	}
+	return locks
+}
+
+// IsLocked tells if any present volume holds a lock which is not stale.
+func (handle *Handle) IsLocked() bool {
+	for _, info := range handle.Locks() {
+		if !info.IsStale() {
+			return true
+		}
+	}
+	return false
+}
+
+// Unlock removes the stale lock files from the present volumes, or every lock file when forced. It returns the cnames
+// of the volumes unlocked.
+func (handle *Handle) Unlock(force bool) ([]string, error) {
+	unlocked := []string{}
+	for _, cname := range handle.Present() {
+		path := filepath.Join(handle.Volumes[cname].MountPoint, md.LOCK_FNAME)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if force || isStaleLockFile(path) {
+			if err := os.Remove(path); err != nil {
+				return unlocked, err
+			}
+			unlocked = append(unlocked, cname)
+		}
+	}
+	return unlocked, nil
+}
+
+// isStaleLockFile tells if the lock file holder is gone, unreadable lock files are considered stale after a grace
+// period, as they might be being written.
+func isStaleLockFile(path string) bool {
+	info, err := readLockFile(path)
+	if err == nil {
+		return info.IsStale()
+	}
+	stat, err := os.Stat(path)
+	return err == nil && time.Since(stat.ModTime()) > LOCK_GRACE
+}
+
+func createLockFile(path string, jsonb []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(jsonb); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// takeOver replaces a stale lock file with ours. Contenders serialise on a takeover file, created exclusively, so the
+// lock is replaced by one of them only; staleness is checked again once holding it, and the lock read back to confirm
+// it is ours. A takeover file left behind by a crashed contender is removed after a grace period. It returns an
+// os.ErrExist error when the lock is not taken over.
+func takeOver(path string, jsonb []byte, token string) error {
+	takeover := path + TAKEOVER_SUFFIX
+	if err := createLockFile(takeover, []byte(token)); err != nil {
+		if stat, statErr := os.Stat(takeover); os.IsExist(err) && statErr == nil && time.Since(stat.ModTime()) > LOCK_GRACE {
+			os.Remove(takeover)
+		}
+		return err
+	}
+	defer os.Remove(takeover)
+	if !isStaleLockFile(path) {
+		return os.ErrExist
+	}
+	if err := writeLockFile(path, jsonb); err != nil {
+		return err
+	}
+	if info, _ := readLockFile(path); info == nil || info.Token != token {
+		return os.ErrExist
+	}
+	return nil
+}
+
+const TAKEOVER_SUFFIX = ".takeover"
+
+// writeLockFile replaces the lock file atomically, by renaming a temporary file over it; so it is never read half
+// written.
+func writeLockFile(path string, jsonb []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(jsonb); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func readLockFile(path string) (*LockInfo, error) {
+	jsonb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info LockInfo
+	if err := json.Unmarshal(jsonb, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
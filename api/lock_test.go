@@ -0,0 +1,200 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+	"io/ioutil"
+	"encoding/json"
+	"time"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock_happyPath(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+
+	lock, err := handle.Lock("test")
+
+	assert.NoError(t, err)
+	for _, cname := range handle.Present() {
+		info, err := readLockFile(filepath.Join(handle.Volumes[cname].MountPoint, md.LOCK_FNAME))
+		assert.NoError(t, err)
+		assert.Equal(t, os.Getpid(), info.Pid)
+		assert.Equal(t, "test", info.Operation)
+	}
+	assert.True(t, handle.IsLocked())
+
+	assert.NoError(t, lock.Release())
+	assert.False(t, handle.IsLocked())
+	assert.Empty(t, handle.Locks())
+}
+
+func TestLock_refusesConcurrentLock(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	lock, _ := handle.Lock("first")
+	defer lock.Release()
+
+	_, err := handle.Lock("second")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "JBOV is locked in volume \"vol1\" by first")
+}
+
+func TestLock_failedLockDoesNotLeaveLockFiles(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	givenLockFile(handle, "vol2", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(time.Hour).Unix()})
+
+	_, err := handle.Lock("test")
+
+	assert.Error(t, err)
+	assert.NotContains(t, handle.Locks(), "vol1")
+}
+
+func TestLock_takesOverStaleLocks(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	host, _ := os.Hostname()
+	givenLockFile(handle, "vol1", LockInfo{Host: host, Pid: givenDeadPid()})
+	givenLockFile(handle, "vol2", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(-time.Minute).Unix()})
+
+	lock, err := handle.Lock("test")
+
+	assert.NoError(t, err)
+	lock.Release()
+}
+
+func TestUnlock_onlyRemovesStaleLocksUnlessForced(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	givenLockFile(handle, "vol1", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(-time.Minute).Unix()})
+	givenLockFile(handle, "vol2", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(time.Hour).Unix()})
+
+	unlocked, err := handle.Unlock(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1"}, unlocked)
+
+	unlocked, err = handle.Unlock(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, unlocked)
+}
+
+func TestRenew_extendsLease(t *testing.T) {
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	lock, _ := handle.Lock("test")
+	defer lock.Release()
+	lock.Info.Expires = 0
+
+	assert.NoError(t, lock.Renew())
+	assert.True(t, handle.Locks()["vol1"].Expires > time.Now().Unix())
+}
+
+func TestLock_renewsTheLeaseWhileHeld(t *testing.T) {
+	defer givenLeaseDuration(1500 * time.Millisecond)()
+	handle, cleanup := givenOpenedJBOV(t)
+	defer cleanup()
+	lock, _ := handle.Lock("test")
+	expires := handle.Locks()["vol1"].Expires
+
+	time.Sleep(1200 * time.Millisecond)
+
+	assert.True(t, handle.Locks()["vol1"].Expires > expires)
+	assert.False(t, handle.Locks()["vol1"].IsStale())
+	lock.Release()
+	time.Sleep(600 * time.Millisecond)
+	assert.Empty(t, handle.Locks())
+}
+
+func TestLock_staleLockIsTakenOverByASingleContender(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handles := []*Handle{}
+	for i := 0; i < 8; i++ {
+		handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+		handles = append(handles, handle)
+	}
+	givenLockFile(handles[0], "vol1", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(-time.Minute).Unix()})
+	givenLockFile(handles[0], "vol2", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(-time.Minute).Unix()})
+
+	locks := make(chan *Lock, len(handles))
+	for _, handle := range handles {
+		go func(handle *Handle) {
+			lock, _ := handle.Lock("test")
+			locks <- lock
+		}(handle)
+	}
+	held := 0
+	for range handles {
+		if lock := <-locks; lock != nil {
+			held++
+			defer lock.Release()
+		}
+	}
+
+	assert.Equal(t, 1, held)
+}
+
+func TestLock_reloadsTheMetadataChangedSinceOpened(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	first, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	second, _ := Open(jbov.Volumes["vol2"].LastMountPoint)
+
+	assert.NoError(t, first.AddRule(md.Rule{Pattern: "*.mk4", Ncopies: 2}))
+	assert.NoError(t, second.AddRule(md.Rule{Pattern: "*.txt", Ncopies: 3}))
+	assert.NoError(t, first.Deprecate("vol2", true))
+
+	reopened, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.Equal(t, []md.Rule{{Pattern: "*.mk4", Ncopies: 2}, {Pattern: "*.txt", Ncopies: 3}}, reopened.Jbov.Rules)
+	assert.True(t, reopened.Jbov.Volumes["vol2"].Deprecated)
+}
+
+func TestOpen_leavesChangesForLaterWhenLockedByOthers(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenLockFile(handle, "vol2", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(time.Hour).Unix()})
+	staged := filepath.Join(jbov.Volumes["vol1"].LastMountPoint, md.JBOV_FNAME+".tmp-1")
+	ioutil.WriteFile(staged, []byte("{}"), 0644)
+
+	_, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, staged)
+	assert.Contains(t, handle.Locks(), "vol2")
+	assert.NotContains(t, handle.Locks(), "vol1")
+}
+
+// utility
+
+func givenLeaseDuration(duration time.Duration) func() {
+	leaseDuration := LeaseDuration
+	LeaseDuration = duration
+	return func() { LeaseDuration = leaseDuration }
+}
+
+func givenOpenedJBOV(t *testing.T) (*Handle, func()) {
+	jbov := givenCreatedJBOV(t)
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handle, func() { cleanupMountPoints(&jbov) }
+}
+
+func givenLockFile(handle *Handle, cname string, info LockInfo) {
+	jsonb, _ := json.Marshal(info)
+	ioutil.WriteFile(filepath.Join(handle.Volumes[cname].MountPoint, md.LOCK_FNAME), jsonb, 0644)
+}
+
+func givenDeadPid() int {
+	pid := 1 << 22
+	for isProcessAlive(pid) {
+		pid++
+	}
+	return pid
+}
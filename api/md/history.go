@@ -0,0 +1,82 @@
+package md
+
+import (
+	"os"
+	"time"
+)
+
+// MAX_HISTORY is the number of edits kept in the metadata history, older ones are forgotten.
+const MAX_HISTORY = 256
+
+// Edit is an entry of the metadata history, Generation is the one it produced (unknown, zero, in older histories).
+type Edit struct {
+	Id         string `json:"id"`
+	Parent     string `json:"parent,omitempty"`
+	Merged     string `json:"merged,omitempty"`
+	Host       string `json:"host"`
+	Ts         int64 `json:"ts"`
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+func GenerateEditId() string {
+	return generateUniqid("EDIT:")
+}
+
+// Head returns the id of the last edit applied to the metadata, or an empty string when it has no history.
+func (jbov *JBOV) Head() string {
+	if len(jbov.History) == 0 {
+		return ""
+	}
+	return jbov.History[len(jbov.History)-1].Id
+}
+
+// HasEdit tells if the given edit is part of this metadata history.
+func (jbov *JBOV) HasEdit(id string) bool {
+	for _, edit := range jbov.History {
+		if edit.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Descends tells if this metadata is the same or a later version of other, that is, other head is in its history. Once
+// the history is cut to MAX_HISTORY edits, a copy older than every edit kept (i.e. a volume offline for long) can not
+// be found in it; it is taken as an ancestor when its generation is lower than the one of the oldest edit kept.
+func (jbov *JBOV) Descends(other *JBOV) bool {
+	if other.Head() == "" || jbov.HasEdit(other.Head()) {
+		return true
+	}
+	if len(jbov.History) < MAX_HISTORY {
+		return false
+	}
+	oldest := jbov.History[0]
+	return oldest.Parent != "" && oldest.Generation > 0 && other.Generation < oldest.Generation
+}
+
+// IsForkOf tells if both metadata copies have been edited independently since their last common edit.
+func (jbov *JBOV) IsForkOf(other *JBOV) bool {
+	return !jbov.Descends(other) && !other.Descends(jbov)
+}
+
+// CommonAncestor returns the id of the last edit found in both histories, or an empty string if there is none.
+func (jbov *JBOV) CommonAncestor(other *JBOV) string {
+	for i := len(jbov.History) - 1; i >= 0; i-- {
+		if other.HasEdit(jbov.History[i].Id) {
+			return jbov.History[i].Id
+		}
+	}
+	return ""
+}
+
+func (jbov *JBOV) recordEdit(merged string) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	edit := Edit{Id: GenerateEditId(), Parent: jbov.Head(), Merged: merged, Host: host, Ts: time.Now().Unix(), Generation: jbov.Generation}
+	jbov.History = append(jbov.History, edit)
+	if len(jbov.History) > MAX_HISTORY {
+		jbov.History = jbov.History[len(jbov.History)-MAX_HISTORY:]
+	}
+}
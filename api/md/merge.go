@@ -0,0 +1,228 @@
+package md
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Conflict struct {
+	Section string
+	Key     string
+	Reason  string
+}
+
+func (conflict Conflict) String() string {
+	return fmt.Sprintf("%s \"%s\": %s", conflict.Section, conflict.Key, conflict.Reason)
+}
+
+// Merge does a three-way merge of two forked metadata copies, given the metadata as it was at their last common edit
+// (base, which can be nil when unknown). Changes done only in one side are taken; when both sides changed the same
+// volume or rule differently a conflict is reported and ours is kept. Deletions pending in both sides are merged.
+func Merge(base, ours, theirs *JBOV) (*JBOV, []Conflict) {
+	if base == nil {
+		base = &JBOV{}
+	}
+	merged := ours.clone()
+	conflicts := []Conflict{}
+
+	if ours.Cname != theirs.Cname && ours.Cname == base.Cname {
+		merged.Cname = theirs.Cname
+	} else if ours.Cname != theirs.Cname && theirs.Cname != base.Cname {
+		conflicts = append(conflicts, Conflict{"cname", ours.Cname, fmt.Sprintf("renamed to \"%s\" in theirs", theirs.Cname)})
+	}
+
+	if ours.HashAlgorithm != theirs.HashAlgorithm && ours.HashAlgorithm == base.HashAlgorithm {
+		merged.HashAlgorithm = theirs.HashAlgorithm
+	} else if ours.HashAlgorithm != theirs.HashAlgorithm && theirs.HashAlgorithm != base.HashAlgorithm {
+		conflicts = append(conflicts, Conflict{"hash-algorithm", ours.Hashing(), fmt.Sprintf("changed to \"%s\" in theirs", theirs.Hashing())})
+	}
+
+	if !sameTrash(ours.Trash, theirs.Trash) && sameTrash(ours.Trash, base.Trash) {
+		merged.Trash = theirs.Trash
+	} else if !sameTrash(ours.Trash, theirs.Trash) && !sameTrash(theirs.Trash, base.Trash) {
+		conflicts = append(conflicts, Conflict{"trash", fmt.Sprintf("%+v", ours.TrashRetention()), fmt.Sprintf("changed to %+v in theirs", theirs.TrashRetention())})
+	}
+
+	merged.Volumes = make(map[string]*Volume)
+	for _, cname := range keys(base.Volumes, ours.Volumes, theirs.Volumes) {
+		b, o, t := base.Volumes[cname], ours.Volumes[cname], theirs.Volumes[cname]
+		switch {
+		case sameVolume(o, t) || sameVolume(t, b):
+			merged.Volumes[cname] = o
+		case sameVolume(o, b):
+			merged.Volumes[cname] = t
+		default:
+			conflicts = append(conflicts, Conflict{"volume", cname, "changed differently in both sides"})
+			merged.Volumes[cname] = o
+		}
+		if merged.Volumes[cname] == nil {
+			delete(merged.Volumes, cname)
+		}
+	}
+
+	merged.Rules, conflicts = mergeRules(base.Rules, ours.Rules, theirs.Rules, conflicts)
+
+	merged.Deleted = make(map[string]*Deleted)
+	for _, path := range keys(base.Deleted, ours.Deleted, theirs.Deleted) {
+		b, o, t := base.Deleted[path], ours.Deleted[path], theirs.Deleted[path]
+		switch {
+		case sameDeleted(o, t) || sameDeleted(t, b):
+			merged.Deleted[path] = o
+		case sameDeleted(o, b):
+			merged.Deleted[path] = t
+		case o == nil:
+			merged.Deleted[path] = t
+		case t == nil:
+			merged.Deleted[path] = o
+		default:
+			merged.Deleted[path] = mergeDeleted(o, t)
+		}
+		if merged.Deleted[path] == nil {
+			delete(merged.Deleted, path)
+		}
+	}
+	if len(merged.Deleted) == 0 {
+		merged.Deleted = nil
+	}
+
+	for _, edit := range theirs.History {
+		if !merged.HasEdit(edit.Id) {
+			merged.History = append(merged.History, edit)
+		}
+	}
+	if theirs.Generation > merged.Generation {
+		merged.Generation = theirs.Generation
+	}
+	merged.Generation++
+	merged.LastModified = time.Now().Unix()
+	merged.recordEdit(theirs.Head())
+
+	return merged.clone(), conflicts
+}
+
+func mergeRules(base, ours, theirs []Rule, conflicts []Conflict) ([]Rule, []Conflict) {
+	added := func(side []Rule) []Rule {
+		rules := []Rule{}
+		for _, rule := range side {
+			if !containsRule(base, rule) {
+				rules = append(rules, rule)
+			}
+		}
+		return rules
+	}
+	oursAdded, theirsAdded := added(ours), added(theirs)
+
+	merged := []Rule{}
+	for _, rule := range base {
+		if containsRule(ours, rule) && containsRule(theirs, rule) {
+			merged = append(merged, rule)
+		}
+	}
+	for _, rule := range oursAdded {
+		merged = append(merged, rule)
+	}
+	for _, rule := range theirsAdded {
+		if containsRule(oursAdded, rule) {
+			continue
+		}
+		conflicting := false
+		for _, other := range oursAdded {
+			conflicting = conflicting || other.Pattern == rule.Pattern
+		}
+		if conflicting {
+			conflicts = append(conflicts, Conflict{"rule", rule.Pattern, "added with different requirements in both sides"})
+			continue
+		}
+		merged = append(merged, rule)
+	}
+	if len(merged) == 0 {
+		merged = nil
+	}
+	return merged, conflicts
+}
+
+func containsRule(rules []Rule, rule Rule) bool {
+	for _, other := range rules {
+		if other == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func sameVolume(a, b *Volume) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Uniqid == b.Uniqid && a.Deprecated == b.Deprecated
+}
+
+func sameTrash(a, b *TrashRetention) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameDeleted(a, b *Deleted) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Ts != b.Ts || a.Size != b.Size || a.Hash != b.Hash || len(a.Pending) != len(b.Pending) {
+		return false
+	}
+	for i := range a.Pending {
+		if a.Pending[i] != b.Pending[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDeleted keeps the volumes still pending in both sides, the ones where either side already applied the deletion
+// are done. The content removed is the one of the latest deletion.
+func mergeDeleted(a, b *Deleted) *Deleted {
+	merged := Deleted{Ts: a.Ts, Pending: []string{}, Size: a.Size, Hash: a.Hash}
+	if b.Ts > merged.Ts {
+		merged.Ts, merged.Size, merged.Hash = b.Ts, b.Size, b.Hash
+	}
+	for _, vol := range a.Pending {
+		for _, other := range b.Pending {
+			if vol == other {
+				merged.Pending = append(merged.Pending, vol)
+			}
+		}
+	}
+	return &merged
+}
+
+func keys(maps ...interface{}) []string {
+	set := make(map[string]bool)
+	for _, m := range maps {
+		switch typed := m.(type) {
+		case map[string]*Volume:
+			for k := range typed {
+				set[k] = true
+			}
+		case map[string]*Deleted:
+			for k := range typed {
+				set[k] = true
+			}
+		}
+	}
+	sorted := []string{}
+	for k := range set {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (jbov *JBOV) clone() *JBOV {
+	var clone JBOV
+	jsonb, _ := json.Marshal(jbov)
+	json.Unmarshal(jsonb, &clone)
+	return &clone
+}
@@ -0,0 +1,141 @@
+package md
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// history
+
+func TestTouch_recordsEditOnTopOfPreviousHead(t *testing.T) {
+	jbov := givenValidJBOV()
+
+	jbov.Touch()
+	first := jbov.Head()
+	jbov.Touch()
+
+	assert.Len(t, jbov.History, 2)
+	assert.Equal(t, first, jbov.History[1].Parent)
+	assert.NotEmpty(t, jbov.History[1].Host)
+}
+
+func TestDescendsAndIsForkOf(t *testing.T) {
+	base := givenValidJBOV()
+	base.Touch()
+	ours, theirs := base.clone(), base.clone()
+	ours.Touch()
+	theirs.Touch()
+
+	assert.True(t, ours.Descends(&base))
+	assert.False(t, base.Descends(ours))
+	assert.True(t, ours.IsForkOf(theirs))
+	assert.False(t, ours.IsForkOf(&base))
+	assert.Equal(t, base.Head(), ours.CommonAncestor(theirs))
+}
+
+func TestDescends_copyOlderThanTheHistoryKept(t *testing.T) {
+	base := givenValidJBOV()
+	base.Touch()
+	stale := base.clone()
+	for i := 0; i <= MAX_HISTORY; i++ {
+		base.Touch()
+	}
+	forked := stale.clone()
+	forked.Touch()
+	forked.Generation = base.Generation + 1
+
+	assert.Len(t, base.History, MAX_HISTORY)
+	assert.False(t, base.HasEdit(stale.Head()))
+	assert.True(t, base.Descends(stale))
+	assert.False(t, base.IsForkOf(stale))
+	assert.True(t, base.IsForkOf(forked))
+}
+
+// merge
+
+func TestMerge_takesChangesFromBothSides(t *testing.T) {
+	base, ours, theirs := givenForks()
+	ours.Rules = append(ours.Rules, Rule{Pattern: "*.jpg", Ncopies: 2})
+	theirs.Rules = theirs.Rules[1:]
+	theirs.Volumes["vol3"] = &Volume{Uniqid: GenerateVolumeUniqId(), LastMountPoint: "/mnt/vol3"}
+	delete(theirs.Deleted, "path/to/file")
+
+	merged, conflicts := Merge(base, ours, theirs)
+
+	assert.Empty(t, conflicts)
+	assert.Equal(t, []Rule{{Pattern: "*.txt", AtLeastACopyIn: "vol1"}, {Pattern: "*.jpg", Ncopies: 2}}, merged.Rules)
+	assert.Len(t, merged.Volumes, 3)
+	assert.NotContains(t, merged.Deleted, "path/to/file")
+	assert.True(t, merged.Descends(ours))
+	assert.True(t, merged.Descends(theirs))
+	assert.True(t, merged.Generation > ours.Generation)
+}
+
+func TestMerge_reportsVolumeConflict(t *testing.T) {
+	base, ours, theirs := givenForks()
+	ours.Volumes["vol1"].Deprecated = true
+	theirs.Volumes["vol1"].Uniqid = GenerateVolumeUniqId()
+
+	merged, conflicts := Merge(base, ours, theirs)
+
+	assert.Equal(t, []Conflict{{Section: "volume", Key: "vol1", Reason: "changed differently in both sides"}}, conflicts)
+	assert.True(t, merged.Volumes["vol1"].Deprecated)
+}
+
+func TestMerge_reportsRuleConflictKeepingOurs(t *testing.T) {
+	base, ours, theirs := givenForks()
+	ours.Rules = append(ours.Rules, Rule{Pattern: "*.jpg", Ncopies: 2})
+	theirs.Rules = append(theirs.Rules, Rule{Pattern: "*.jpg", Ncopies: 3}, Rule{Pattern: "*.png", Ncopies: 2})
+
+	merged, conflicts := Merge(base, ours, theirs)
+
+	assert.Equal(t, []Conflict{{Section: "rule", Key: "*.jpg", Reason: "added with different requirements in both sides"}}, conflicts)
+	assert.Equal(t, append(base.Rules, Rule{Pattern: "*.jpg", Ncopies: 2}, Rule{Pattern: "*.png", Ncopies: 2}), merged.Rules)
+}
+
+func TestMerge_pendingDeletionsAreIntersected(t *testing.T) {
+	base, ours, theirs := givenForks()
+	ours.Deleted["path/other/file"].Pending = []string{"vol2"}
+	theirs.Deleted["path/other/file"].Pending = []string{"vol1"}
+
+	merged, conflicts := Merge(base, ours, theirs)
+
+	assert.Empty(t, conflicts)
+	assert.Empty(t, merged.Deleted["path/other/file"].Pending)
+}
+
+func TestMerge_trashRetention(t *testing.T) {
+	base, ours, theirs := givenForks()
+	theirs.Trash = &TrashRetention{MaxAgeDays: 7}
+
+	merged, conflicts := Merge(base, ours, theirs)
+
+	assert.Empty(t, conflicts)
+	assert.Equal(t, &TrashRetention{MaxAgeDays: 7}, merged.Trash)
+
+	ours.Trash = &TrashRetention{MaxBytes: 1024}
+	_, conflicts = Merge(base, ours, theirs)
+
+	assert.Len(t, conflicts, 1)
+}
+
+func TestMerge_withoutBase(t *testing.T) {
+	_, ours, theirs := givenForks()
+	theirs.Rules = append(theirs.Rules, Rule{Pattern: "*.jpg", Ncopies: 2})
+
+	merged, conflicts := Merge(nil, ours, theirs)
+
+	assert.Empty(t, conflicts)
+	assert.Len(t, merged.Rules, 3)
+}
+
+// utils
+
+func givenForks() (*JBOV, *JBOV, *JBOV) {
+	base := givenValidJBOV()
+	base.Touch()
+	ours, theirs := base.clone(), base.clone()
+	ours.Touch()
+	theirs.Touch()
+	return &base, ours, theirs
+}
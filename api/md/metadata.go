@@ -6,40 +6,65 @@ import (
 	"regexp"
 	"crypto/rand"
 	"errors"
+	"time"
+	"github.com/kuking/jbov/api/hashing"
 )
 
 const JBOV_FNAME = ".jbov.metadata"
 const UNIQID_FNAME = ".jbov.uniqid"
 const LOCK_FNAME = ".jbov.lock"
+const HISTORY_DIRNAME = ".jbov.history"
 
 var RE_JBOV_UNIQ = regexp.MustCompile("^JBOV:[0-9a-f]{16,64}$")
 var RE_VOL_UNIQ = regexp.MustCompile("^VOL:[0-9a-f]{16,64}$")
 var RE_VALID_CNAME = regexp.MustCompile("^[a-z0-9_]{3,20}$")
 
 type JBOV struct {
+	SchemaVersion  int `json:"schema-version"`
 	Cname          string `json:"cname"`
 	Uniqid         string `json:"uniqid"`
 	LastMountPoint string `json:"last-mount-point"`
-	Volumes        map[string]*Volume `json:"volumes""`
+	Generation     uint64 `json:"generation"`
+	LastModified   int64 `json:"last-modified"`
+	Volumes        map[string]*Volume `json:"volumes"`
+	HashAlgorithm  string `json:"hash-algorithm,omitempty"`
+	Trash          *TrashRetention `json:"trash,omitempty"`
 	Rules          []Rule `json:"rules,omitempty"`
 	Deleted        map[string]*Deleted `json:"deleted,omitempty"`
+	History        []Edit `json:"history,omitempty"`
+	Signature      string `json:"signature,omitempty"`
 }
 
 type Volume struct {
 	Uniqid         string `json:"uniqid"`
 	LastMountPoint string `json:"last-mount-point"`
-	Deprecated     bool `json:"deprecated,omitempty""`
+	Deprecated     bool `json:"deprecated,omitempty"`
+	Filesystem     string `json:"filesystem,omitempty"`
 }
 
+// Rule sets the redundancy required for the files matching its pattern. When rules overlap, the ones with the highest
+// Priority govern a file and the rest are overridden, see JBOV.Governing.
 type Rule struct {
 	Pattern        string `json:"pattern"`
 	AtLeastACopyIn string `json:"at-least-a-copy-in,omitempty"`
-	Ncopies        int `json:"ncopies,omitempty"`
+	Ncopies        Copies `json:"ncopies,omitempty"`
+	Priority       int `json:"priority,omitempty"`
 }
 
+// TrashRetention is how long files deleted, or overwritten, by jbov are kept in the volumes trash: up to MaxAgeDays
+// days and, per volume, up to MaxBytes bytes (the oldest are purged first); zero is no limit.
+type TrashRetention struct {
+	MaxAgeDays int `json:"max-age-days,omitempty"`
+	MaxBytes   int64 `json:"max-bytes,omitempty"`
+}
+
+// Deleted is the tombstone of a removed file. Size and Hash are of the content removed, when known; a copy found later
+// in a volume no longer pending, and not matching them, is a new file.
 type Deleted struct {
 	Ts      int `json:"ts"`
 	Pending []string `json:"pending"`
+	Size    int64 `json:"size,omitempty"`
+	Hash    string `json:"hash,omitempty"`
 }
 
 func generateUniqid(prefix string) string {
@@ -56,6 +81,10 @@ func GenerateJbovUniqId() string {
 	return generateUniqid("JBOV:")
 }
 
+func GenerateLockToken() string {
+	return generateUniqid("LOCK:")
+}
+
 func IsJbovUniqId(uniqid *string) bool {
 	return RE_JBOV_UNIQ.MatchString(*uniqid)
 }
@@ -86,6 +115,12 @@ func (jbov *JBOV) IsValid() (bool, error) {
 			return false, errors.New("JBOV volume has an invalid uniqid")
 		}
 	}
+	if jbov.HashAlgorithm != "" && !hashing.IsSupported(jbov.HashAlgorithm) {
+		return false, errors.New(fmt.Sprintf("JBOV hash algorithm is not supported: %s", jbov.HashAlgorithm))
+	}
+	if jbov.Trash != nil && (jbov.Trash.MaxAgeDays < 0 || jbov.Trash.MaxBytes < 0) {
+		return false, errors.New("JBOV trash retention can not be negative")
+	}
 	for _, deleted := range jbov.Deleted {
 		for _, volp := range deleted.Pending {
 			if _, ok := jbov.Volumes[volp]; !ok {
@@ -93,8 +128,16 @@ func (jbov *JBOV) IsValid() (bool, error) {
 			}
 		}
 	}
+	// rule patterns are not checked, a pattern written before the pattern language changed might no longer be well
+	// formed and the metadata must still load; such rules match nothing, they are reported once loaded
 	for i := 0; i < len(jbov.Rules); i++ {
 		rule := jbov.Rules[i]
+		if rule.Pattern == "" {
+			return false, errors.New("JBOV rule has no pattern")
+		}
+		if err := rule.validateRequirement(); err != nil {
+			return false, err
+		}
 		if rule.AtLeastACopyIn != "" {
 			if _, ok := jbov.Volumes[rule.AtLeastACopyIn]; !ok {
 				return false, errors.New(fmt.Sprintf("JBOV rule at-least-a-copy-in refers to an invalid volume: %s", rule.AtLeastACopyIn))
@@ -104,12 +147,43 @@ func (jbov *JBOV) IsValid() (bool, error) {
 	return true, nil
 }
 
-func (jbov *JBOV) Marshal() ([]byte, error) {
-	return json.MarshalIndent(jbov, "", "    ")
+// DEFAULT_TRASH_MAX_AGE_DAYS is the trash retention when there is none configured.
+const DEFAULT_TRASH_MAX_AGE_DAYS = 30
+
+// TrashRetention returns the trash retention configured, the default one when there is none.
+func (jbov *JBOV) TrashRetention() TrashRetention {
+	if jbov.Trash == nil {
+		return TrashRetention{MaxAgeDays: DEFAULT_TRASH_MAX_AGE_DAYS}
+	}
+	return *jbov.Trash
+}
+
+// Hashing returns the algorithm used to hash the JBOV files content.
+func (jbov *JBOV) Hashing() string {
+	if jbov.HashAlgorithm == "" {
+		return hashing.DEFAULT
+	}
+	return jbov.HashAlgorithm
+}
+
+// Touch records a new edit of the metadata, so its copies can be told apart.
+func (jbov *JBOV) Touch() {
+	jbov.Generation++
+	jbov.LastModified = time.Now().Unix()
+	jbov.recordEdit("")
 }
 
-func (JBOV) Unmarshall(jsonbytes *[]byte) JBOV {
-	var jbov JBOV
-	json.Unmarshal(*jsonbytes, &jbov)
-	return jbov
+// IsNewerThan tells which of two copies of the same JBOV metadata wins, the generation counter takes precedence over
+// the last modified timestamp.
+func (jbov *JBOV) IsNewerThan(other *JBOV) bool {
+	if jbov.Generation != other.Generation {
+		return jbov.Generation > other.Generation
+	}
+	return jbov.LastModified > other.LastModified
+}
+
+// Marshal serialises the metadata in the current schema version.
+func (jbov *JBOV) Marshal() ([]byte, error) {
+	jbov.SchemaVersion = SCHEMA_VERSION
+	return json.MarshalIndent(jbov, "", "    ")
 }
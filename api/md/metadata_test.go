@@ -3,6 +3,7 @@ package md
 import (
 	"testing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // uniq ids creation and validation
@@ -66,9 +67,8 @@ func TestMarshal(t *testing.T) {
 }
 
 func TestUnmarshal(t *testing.T) {
-	jsonbytes := []byte(givenAValidJson())
-
-	jbov := JBOV{}.Unmarshall(&jsonbytes)
+	jbov, _, err := Load([]byte(givenAValidJson()))
+	require.NoError(t, err)
 
 	ok, err := jbov.IsValid()
 	assert.True(t, ok)
@@ -78,10 +78,12 @@ func TestUnmarshal(t *testing.T) {
 func TestRoundTripMarshaller(t *testing.T) {
 	jbov := givenValidJBOV()
 
-	content, _ := jbov.Marshal()
-	jbov2 := JBOV{}.Unmarshall(&content)
+	content, err := jbov.Marshal()
+	require.NoError(t, err)
+	jbov2, _, err := Load(content)
+	require.NoError(t, err)
 
-	assert.Equal(t, jbov, jbov2)
+	assert.Equal(t, jbov, *jbov2)
 }
 
 func TestIsValid_happyPath(t *testing.T) {
@@ -165,6 +167,54 @@ func TestIsValid_DeletedFileWithReferenceToInvalidVolume(t *testing.T) {
 	assert.EqualError(t, err, "JBOV deleted pending refers to invalid volume: nonexistent")
 }
 
+func TestIsValid_UnsupportedHashAlgorithm(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.HashAlgorithm = "md5"
+
+	ok, err := jbov.IsValid()
+
+	assert.False(t, ok)
+	assert.EqualError(t, err, "JBOV hash algorithm is not supported: md5")
+}
+
+func TestIsValid_NegativeTrashRetention(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Trash = &TrashRetention{MaxAgeDays: -1}
+
+	ok, err := jbov.IsValid()
+
+	assert.False(t, ok)
+	assert.EqualError(t, err, "JBOV trash retention can not be negative")
+}
+
+// generations
+
+func TestTouch_increasesGeneration(t *testing.T) {
+	jbov := givenValidJBOV()
+
+	jbov.Touch()
+	jbov.Touch()
+
+	assert.Equal(t, uint64(2), jbov.Generation)
+	assert.NotZero(t, jbov.LastModified)
+}
+
+func TestIsNewerThan(t *testing.T) {
+	older := givenValidJBOV()
+	newer := givenValidJBOV()
+	newer.Generation = 2
+	older.Generation = 1
+	older.LastModified = 100
+
+	assert.True(t, newer.IsNewerThan(&older))
+	assert.False(t, older.IsNewerThan(&newer))
+
+	newer.Generation = 1
+	newer.LastModified = 101
+	assert.True(t, newer.IsNewerThan(&older))
+	assert.False(t, older.IsNewerThan(&older))
+}
+
 // utils
 
 func givenValidJBOV() JBOV {
@@ -175,7 +225,7 @@ func givenValidJBOV() JBOV {
 	rules := []Rule{{Pattern: "*.mk4", Ncopies: 1}, {Pattern: "*.txt", AtLeastACopyIn: "vol1"}}
 
 	deleted := make(map[string]*Deleted)
-	deleted["path/to/file"] = &Deleted{Ts: 1, Pending: []string{"vol1"}}
+	deleted["path/to/file"] = &Deleted{Ts: 1, Pending: []string{"vol1"}, Size: 4, Hash: "sha256:00"}
 	deleted["path/other/file"] = &Deleted{Ts: 1, Pending: []string{"vol1", "vol2"}}
 
 	return JBOV{Cname: "valid", Uniqid: GenerateJbovUniqId(), Volumes: vols, Rules: rules, Deleted: deleted}
@@ -183,9 +233,12 @@ func givenValidJBOV() JBOV {
 
 func givenAValidJson() string {
 	expected := `{
+			"schema-version": 2,
 			"cname": "valid",
 			"uniqid": "JBOV:0000000000000000000000000000000000000000",
 			"last-mount-point": "",
+			"generation": 0,
+			"last-modified": 0,
 			"volumes": {
 				"vol1": {
 					"uniqid": "VOL:1111111111111111111111111111111111111111",
@@ -202,7 +255,7 @@ func givenAValidJson() string {
 			],
 			"deleted": {
 				"path/other/file": { "ts": 1, "pending": [ "vol1", "vol2" ] },
-				"path/to/file": { "ts": 1, "pending": [ "vol1" ] }
+				"path/to/file": { "ts": 1, "pending": [ "vol1" ], "size": 4, "hash": "sha256:00" }
 			}
 		}`
 	return expected
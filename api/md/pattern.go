@@ -0,0 +1,243 @@
+package md
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// A rule pattern is one or more terms separated by spaces, a file matches it when it matches every term. A term is
+// negated by a leading "!". The terms are:
+//
+//	*.mk4             a glob matched against the file name, in any directory
+//	films/**/*.mk4    a glob with a slash is matched against the whole path, "**" spans directories
+//	/notes.txt        a leading slash anchors a glob to the volume root
+//	films/            a trailing slash matches every file beneath that directory (anchored to the root)
+//	re:^films/.*\.mk4$  a regular expression matched against the whole path
+//	size>1GiB         the file size, with <, <=, >, >= and the units B, K/KB, M/MB, G/GB, T/TB (powers of 1000)
+//	                  or KiB, MiB, GiB, TiB (powers of 1024)
+//	age>30d           the time since the file was last modified, with the same comparisons and the units s, m, h,
+//	                  d and w
+//	ext:mkv,mp4       the file extension, any of the ones listed and regardless of case
+//
+// Globs follow github.com/bmatcuk/doublestar, a backslash escapes the following character, i.e. a space.
+
+type term struct {
+	match func(filePath string, size int64, mtime int64) bool
+	// stat is set when it depends on the file size or age, not only on its path
+	stat bool
+}
+
+type matcher []term
+
+var matchers = struct {
+	sync.Mutex
+	compiled map[string]matcher
+}{compiled: make(map[string]matcher)}
+
+// matcherOf returns the compiled pattern, compiling it the first time.
+func matcherOf(pattern string) (matcher, error) {
+	matchers.Lock()
+	defer matchers.Unlock()
+	if compiled, ok := matchers.compiled[pattern]; ok {
+		return compiled, nil
+	}
+	compiled, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	matchers.compiled[pattern] = compiled
+	return compiled, nil
+}
+
+func compilePattern(pattern string) (matcher, error) {
+	compiled := matcher{}
+	for _, source := range splitTerms(pattern) {
+		compiledTerm, err := compileTerm(source)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, compiledTerm)
+	}
+	if len(compiled) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+	return compiled, nil
+}
+
+func (compiled matcher) matches(filePath string, size int64, mtime int64) bool {
+	for _, compiledTerm := range compiled {
+		if !compiledTerm.match(filePath, size, mtime) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPath tells whether a file matches given only its path, known is false when it depends on its size or age.
+func (compiled matcher) matchesPath(filePath string) (matches bool, known bool) {
+	known = true
+	for _, compiledTerm := range compiled {
+		if compiledTerm.stat {
+			known = false
+		} else if !compiledTerm.match(filePath, 0, 0) {
+			return false, true
+		}
+	}
+	return known, known
+}
+
+// splitTerms splits a pattern on the spaces not escaped by a backslash.
+func splitTerms(pattern string) []string {
+	terms := []string{}
+	current := strings.Builder{}
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ' ' || r == '\t':
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms
+}
+
+func compileTerm(source string) (term, error) {
+	if strings.HasPrefix(source, "!") {
+		negated, err := compileTerm(source[1:])
+		if err != nil {
+			return term{}, err
+		}
+		return term{func(filePath string, size int64, mtime int64) bool {
+			return !negated.match(filePath, size, mtime)
+		}, negated.stat}, nil
+	}
+	switch {
+	case source == "":
+		return term{}, errors.New("\"!\" negates nothing")
+	case strings.HasPrefix(source, "re:"):
+		re, err := regexp.Compile(source[3:])
+		if err != nil {
+			return term{}, errors.New(fmt.Sprintf("%s, %s", source, err))
+		}
+		return term{func(filePath string, size int64, mtime int64) bool {
+			return re.MatchString(filePath)
+		}, false}, nil
+	case strings.HasPrefix(source, "ext:"):
+		exts := strings.Split(strings.ToLower(source[4:]), ",")
+		for _, ext := range exts {
+			if ext == "" || strings.ContainsAny(ext, "/.") {
+				return term{}, errors.New(fmt.Sprintf("%s, expected extensions like ext:mkv,mp4", source))
+			}
+		}
+		return term{func(filePath string, size int64, mtime int64) bool {
+			ext := strings.ToLower(strings.TrimPrefix(path.Ext(filePath), "."))
+			return contains(exts, ext)
+		}, false}, nil
+	case strings.HasPrefix(source, "size") && isComparison(source[4:]):
+		compare, value := comparison(source[4:])
+		limit, err := ParseSize(value)
+		if err != nil {
+			return term{}, errors.New(fmt.Sprintf("%s, expected a size like size>1GiB", source))
+		}
+		return term{func(filePath string, size int64, mtime int64) bool {
+			return compare(size, limit)
+		}, true}, nil
+	case strings.HasPrefix(source, "age") && isComparison(source[3:]):
+		compare, value := comparison(source[3:])
+		limit, err := parseAge(value)
+		if err != nil {
+			return term{}, errors.New(fmt.Sprintf("%s, expected an age like age>30d", source))
+		}
+		return term{func(filePath string, size int64, mtime int64) bool {
+			return compare(time.Now().UnixNano()-mtime, int64(limit))
+		}, true}, nil
+	}
+	return compileGlob(source)
+}
+
+func compileGlob(source string) (term, error) {
+	glob := source
+	whole := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+	if glob == "" || !doublestar.ValidatePattern(glob) {
+		return term{}, errors.New(source)
+	}
+	return term{func(filePath string, size int64, mtime int64) bool {
+		if !whole {
+			filePath = path.Base(filePath)
+		}
+		return doublestar.MatchUnvalidated(glob, filePath)
+	}, false}, nil
+}
+
+func isComparison(value string) bool {
+	return strings.HasPrefix(value, "<") || strings.HasPrefix(value, ">")
+}
+
+// comparison returns the comparison a term starts with, and the value following it.
+func comparison(value string) (func(a, b int64) bool, string) {
+	switch {
+	case strings.HasPrefix(value, "<="):
+		return func(a, b int64) bool { return a <= b }, value[2:]
+	case strings.HasPrefix(value, ">="):
+		return func(a, b int64) bool { return a >= b }, value[2:]
+	case strings.HasPrefix(value, "<"):
+		return func(a, b int64) bool { return a < b }, value[1:]
+	}
+	return func(a, b int64) bool { return a > b }, value[1:]
+}
+
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1,
+	"K": 1000, "KB": 1000, "M": 1000 * 1000, "MB": 1000 * 1000,
+	"G": 1000 * 1000 * 1000, "GB": 1000 * 1000 * 1000, "T": 1000 * 1000 * 1000 * 1000, "TB": 1000 * 1000 * 1000 * 1000,
+	"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40,
+}
+
+// ParseSize parses a size in bytes with an optional unit, as in rule patterns: B, K/KB, M/MB, G/GB, T/TB (powers of
+// 1000) or KiB, MiB, GiB, TiB (powers of 1024), i.e. 500GB. Sizes overflowing an int64 are not valid.
+func ParseSize(value string) (int64, error) {
+	number := strings.TrimRightFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	multiplier, ok := sizeUnits[strings.ToUpper(value[len(number):])]
+	size, err := strconv.ParseInt(number, 10, 64)
+	if !ok || err != nil || size < 0 || size > math.MaxInt64/multiplier {
+		return 0, errors.New(fmt.Sprintf("invalid size \"%s\"", value))
+	}
+	return size * multiplier, nil
+}
+
+var ageUnits = map[string]time.Duration{
+	"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour,
+}
+
+func parseAge(value string) (time.Duration, error) {
+	number := strings.TrimRightFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	unit, ok := ageUnits[value[len(number):]]
+	age, err := strconv.ParseInt(number, 10, 64)
+	if !ok || err != nil || age > math.MaxInt64/int64(unit) {
+		return 0, errors.New(fmt.Sprintf("invalid age \"%s\"", value))
+	}
+	return time.Duration(age) * unit, nil
+}
@@ -0,0 +1,116 @@
+package md
+
+import (
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPattern_globs(t *testing.T) {
+	assert.True(t, givenMatches("films/**/*.mk4", "films/2018/summer/film.mk4"))
+	assert.True(t, givenMatches("films/**/*.mk4", "films/film.mk4"))
+	assert.False(t, givenMatches("films/**/*.mk4", "docs/films/film.mk4"))
+	assert.True(t, givenMatches("*.{mk4,avi}", "films/film.avi"))
+	assert.True(t, givenMatches("/notes.txt", "notes.txt"))
+	assert.False(t, givenMatches("/notes.txt", "docs/notes.txt"))
+	assert.True(t, givenMatches(`my\ film.mk4`, "films/my film.mk4"))
+}
+
+func TestPattern_directoryPrefix(t *testing.T) {
+	assert.True(t, givenMatches("films/", "films/film.mk4"))
+	assert.True(t, givenMatches("films/", "films/2018/film.mk4"))
+	assert.False(t, givenMatches("films/", "old/films/film.mk4"))
+	assert.False(t, givenMatches("films/", "films.txt"))
+}
+
+func TestPattern_regex(t *testing.T) {
+	assert.True(t, givenMatches(`re:^films/\d{4}/`, "films/2018/film.mk4"))
+	assert.False(t, givenMatches(`re:^films/\d{4}/`, "films/new/film.mk4"))
+	assert.True(t, givenMatches(`re:\.MK4$`, "FILM.MK4"))
+}
+
+func TestPattern_negationAndPredicates(t *testing.T) {
+	now := time.Now().UnixNano()
+	old := time.Now().Add(-40 * 24 * time.Hour).UnixNano()
+	big, small := int64(2<<30), int64(1000)
+
+	rule := Rule{Pattern: "films/ !*.tmp size>1GiB"}
+	assert.True(t, rule.Matches("films/film.mk4", big, now))
+	assert.False(t, rule.Matches("films/film.tmp", big, now))
+	assert.False(t, rule.Matches("films/film.mk4", small, now))
+
+	rule = Rule{Pattern: "age>=30d ext:JPG,png"}
+	assert.True(t, rule.Matches("photos/photo.jpg", small, old))
+	assert.False(t, rule.Matches("photos/photo.jpg", small, now))
+	assert.False(t, rule.Matches("photos/photo.gif", small, old))
+
+	rule = Rule{Pattern: "size<=1KB !age<1w"}
+	assert.True(t, rule.Matches("notes.txt", small, old))
+	assert.False(t, rule.Matches("notes.txt", small+1, old))
+	assert.False(t, rule.Matches("notes.txt", small, now))
+}
+
+func TestPattern_matchesPath(t *testing.T) {
+	matches, known := (&Rule{Pattern: "films/ !*.tmp"}).MatchesPath("films/film.mk4")
+	assert.True(t, matches)
+	assert.True(t, known)
+
+	matches, known = (&Rule{Pattern: "films/ size>1GiB"}).MatchesPath("docs/notes.txt")
+	assert.False(t, matches)
+	assert.True(t, known)
+
+	matches, known = (&Rule{Pattern: "films/ !age<1w"}).MatchesPath("films/film.mk4")
+	assert.False(t, matches)
+	assert.False(t, known)
+}
+
+func TestPattern_invalid(t *testing.T) {
+	assert.EqualError(t, (&Rule{Pattern: "films/[a", Ncopies: 2}).Validate(), "JBOV rule pattern is not valid: films/[a")
+	assert.EqualError(t, (&Rule{Pattern: "re:films/(", Ncopies: 2}).Validate(),
+		"JBOV rule pattern is not valid: re:films/(, error parsing regexp: missing closing ): `films/(`")
+	assert.EqualError(t, (&Rule{Pattern: "size>1XB", Ncopies: 2}).Validate(),
+		"JBOV rule pattern is not valid: size>1XB, expected a size like size>1GiB")
+	assert.EqualError(t, (&Rule{Pattern: "age>soon", Ncopies: 2}).Validate(),
+		"JBOV rule pattern is not valid: age>soon, expected an age like age>30d")
+	assert.EqualError(t, (&Rule{Pattern: "ext:", Ncopies: 2}).Validate(),
+		"JBOV rule pattern is not valid: ext:, expected extensions like ext:mkv,mp4")
+	assert.EqualError(t, (&Rule{Pattern: "*.mk4 !", Ncopies: 2}).Validate(), "JBOV rule pattern is not valid: \"!\" negates nothing")
+	assert.False(t, (&Rule{Pattern: "films/[a"}).Matches("films/[a", 0, 0))
+}
+
+func TestPattern_overflowingSizesAndAges(t *testing.T) {
+	assert.Error(t, (&Rule{Pattern: "size>9000000TiB", Ncopies: 2}).Validate())
+	assert.NoError(t, (&Rule{Pattern: "size>8000000TiB", Ncopies: 2}).Validate())
+	assert.Error(t, (&Rule{Pattern: "age>20000w", Ncopies: 2}).Validate())
+}
+
+func TestParseSize(t *testing.T) {
+	size, err := ParseSize("500G")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500*1000*1000*1000), size)
+	size, _ = ParseSize("2TiB")
+	assert.Equal(t, int64(2<<40), size)
+	_, err = ParseSize("99999999999T")
+	assert.EqualError(t, err, "invalid size \"99999999999T\"")
+	_, err = ParseSize("-1")
+	assert.Error(t, err)
+}
+
+func TestIsValid_InvalidRulePatternMatchesNothing(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Rules = append(jbov.Rules, Rule{Pattern: "re:(", Ncopies: 2})
+
+	valid, err := jbov.IsValid()
+
+	assert.True(t, valid)
+	assert.NoError(t, err)
+	assert.Error(t, jbov.Rules[2].PatternError())
+	assert.False(t, jbov.Rules[2].Matches("re:(", 0, 0))
+}
+
+// utility
+
+func givenMatches(pattern string, filePath string) bool {
+	rule := Rule{Pattern: pattern}
+	return rule.Matches(filePath, 0, 0)
+}
@@ -0,0 +1,189 @@
+package md
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Copies is the number of copies a rule requires. ALL_VOLUMES, written as "*", requires a copy in every volume which is
+// not deprecated; however many there are when the rule is evaluated.
+type Copies int
+
+const ALL_VOLUMES Copies = -1
+
+func (copies Copies) String() string {
+	if copies == ALL_VOLUMES {
+		return "*"
+	}
+	return strconv.Itoa(int(copies))
+}
+
+// ParseCopies parses a number of copies, or "*" for a copy in every volume.
+func ParseCopies(value string) (Copies, error) {
+	if value == "*" {
+		return ALL_VOLUMES, nil
+	}
+	copies, err := strconv.Atoi(value)
+	if err != nil || copies < 0 {
+		return 0, errors.New(fmt.Sprintf("invalid number of copies \"%s\", expected a number or *", value))
+	}
+	return Copies(copies), nil
+}
+
+func (copies Copies) MarshalJSON() ([]byte, error) {
+	if copies == ALL_VOLUMES {
+		return []byte(`"*"`), nil
+	}
+	return json.Marshal(int(copies))
+}
+
+func (copies *Copies) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "*" {
+			*copies = ALL_VOLUMES
+			return nil
+		}
+	case float64:
+		if v >= 0 && v == float64(int(v)) {
+			*copies = Copies(v)
+			return nil
+		}
+	}
+	return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*copies)}
+}
+
+// Matches tells whether the rule applies to a file, given its slash separated path, size and modification time (unix
+// nanoseconds). See pattern.go for the pattern language; an invalid pattern matches nothing.
+func (rule *Rule) Matches(filePath string, size int64, mtime int64) bool {
+	compiled, err := matcherOf(rule.Pattern)
+	return err == nil && compiled.matches(filePath, size, mtime)
+}
+
+// MatchesPath tells whether the rule applies to a file given only its path, i.e. a file not found; known is false when
+// that depends on the size or age of the file.
+func (rule *Rule) MatchesPath(filePath string) (matches bool, known bool) {
+	compiled, err := matcherOf(rule.Pattern)
+	if err != nil {
+		return false, true
+	}
+	return compiled.matchesPath(filePath)
+}
+
+// Validate checks the rule on its own: its pattern must be well formed and it must require something, either a number
+// of copies or a copy in a volume. The volume it refers to is checked along with the rest of the metadata.
+func (rule *Rule) Validate() error {
+	if err := rule.PatternError(); err != nil {
+		return err
+	}
+	return rule.validateRequirement()
+}
+
+// PatternError tells why the rule pattern is not well formed, it is nil when it is.
+func (rule *Rule) PatternError() error {
+	if rule.Pattern == "" {
+		return errors.New("JBOV rule has no pattern")
+	}
+	if _, err := matcherOf(rule.Pattern); err != nil {
+		return errors.New(fmt.Sprintf("JBOV rule pattern is not valid: %s", err))
+	}
+	return nil
+}
+
+func (rule *Rule) validateRequirement() error {
+	if rule.Ncopies < 0 && rule.Ncopies != ALL_VOLUMES {
+		return errors.New(fmt.Sprintf("JBOV rule number of copies can not be negative: %d", rule.Ncopies))
+	}
+	if rule.Ncopies == 0 && rule.AtLeastACopyIn == "" {
+		return errors.New(fmt.Sprintf("JBOV rule \"%s\" requires nothing, it needs a number of copies or a volume", rule.Pattern))
+	}
+	return nil
+}
+
+// Governing returns the index of the rules governing a file: out of the rules matching it, the ones with the highest
+// priority. Rules with a lower priority are overridden, i.e. a priority 1 rule "films/ *.tmp" requiring a copy takes
+// temporary files out of a priority 0 rule "films/" requiring three. Rules with the same priority combine.
+func (jbov *JBOV) Governing(filePath string, size int64, mtime int64) []int {
+	governing := []int{}
+	for i := range jbov.Rules {
+		rule := &jbov.Rules[i]
+		if !rule.Matches(filePath, size, mtime) {
+			continue
+		}
+		if len(governing) > 0 && rule.Priority < jbov.Rules[governing[0]].Priority {
+			continue
+		}
+		if len(governing) > 0 && rule.Priority > jbov.Rules[governing[0]].Priority {
+			governing = governing[:0]
+		}
+		governing = append(governing, i)
+	}
+	return governing
+}
+
+// Requirement returns the redundancy required for a file out of the rules governing it: the largest number of copies
+// and every volume which should hold one (sorted). A copy in every volume is as many copies as volumes not deprecated.
+func (jbov *JBOV) Requirement(filePath string, size int64, mtime int64) (ncopies int, in []string) {
+	in = []string{}
+	for _, i := range jbov.Governing(filePath, size, mtime) {
+		rule := &jbov.Rules[i]
+		if copies := jbov.resolve(rule.Ncopies); copies > ncopies {
+			ncopies = copies
+		}
+		if rule.AtLeastACopyIn != "" && !contains(in, rule.AtLeastACopyIn) {
+			in = append(in, rule.AtLeastACopyIn)
+		}
+	}
+	sort.Strings(in)
+	return ncopies, in
+}
+
+// Replication weighs the volumes holding a file against the redundancy it requires: the number of copies required, the
+// copies held (by volumes not deprecated) and the volumes required to hold a copy which do not (sorted). The file is
+// under-replicated when it holds fewer copies than required, or a required volume does not hold it.
+func (jbov *JBOV) Replication(filePath string, size int64, mtime int64, holders []string) (ncopies int, copies int, missingIn []string) {
+	ncopies, in := jbov.Requirement(filePath, size, mtime)
+	for _, cname := range holders {
+		if vol, ok := jbov.Volumes[cname]; ok && !vol.Deprecated {
+			copies++
+		}
+	}
+	missingIn = []string{}
+	for _, cname := range in {
+		if !contains(holders, cname) {
+			missingIn = append(missingIn, cname)
+		}
+	}
+	return ncopies, copies, missingIn
+}
+
+// resolve turns a number of copies into the actual number, given the current volumes.
+func (jbov *JBOV) resolve(copies Copies) int {
+	if copies != ALL_VOLUMES {
+		return int(copies)
+	}
+	active := 0
+	for _, vol := range jbov.Volumes {
+		if !vol.Deprecated {
+			active++
+		}
+	}
+	return active
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
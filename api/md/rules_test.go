@@ -0,0 +1,121 @@
+package md
+
+import (
+	"testing"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleMatches(t *testing.T) {
+	byName := Rule{Pattern: "*.mk4"}
+	byPath := Rule{Pattern: "films/*.mk4"}
+
+	assert.True(t, byName.Matches("film.mk4", 0, 0))
+	assert.True(t, byName.Matches("films/2018/film.mk4", 0, 0))
+	assert.False(t, byName.Matches("film.txt", 0, 0))
+	assert.True(t, byPath.Matches("films/film.mk4", 0, 0))
+	assert.False(t, byPath.Matches("films/2018/film.mk4", 0, 0))
+	assert.False(t, byPath.Matches("film.mk4", 0, 0))
+}
+
+func TestRequirement_combinesEveryMatchingRule(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Rules = append(jbov.Rules, Rule{Pattern: "important/*", Ncopies: 2, AtLeastACopyIn: "vol2"})
+
+	ncopies, in := jbov.Requirement("important/notes.txt", 0, 0)
+
+	assert.Equal(t, 2, ncopies)
+	assert.Equal(t, []string{"vol1", "vol2"}, in)
+}
+
+func TestRequirement_noMatchingRule(t *testing.T) {
+	jbov := givenValidJBOV()
+
+	ncopies, in := jbov.Requirement("photo.jpg", 0, 0)
+
+	assert.Equal(t, 0, ncopies)
+	assert.Empty(t, in)
+}
+
+func TestRuleValidate(t *testing.T) {
+	assert.NoError(t, (&Rule{Pattern: "*.mk4", Ncopies: 2}).Validate())
+	assert.NoError(t, (&Rule{Pattern: "*.mk4", AtLeastACopyIn: "vol1"}).Validate())
+	assert.EqualError(t, (&Rule{Ncopies: 2}).Validate(), "JBOV rule has no pattern")
+	assert.EqualError(t, (&Rule{Pattern: "[*.mk4", Ncopies: 2}).Validate(), "JBOV rule pattern is not valid: [*.mk4")
+	assert.EqualError(t, (&Rule{Pattern: "*.mk4", Ncopies: -2}).Validate(), "JBOV rule number of copies can not be negative: -2")
+	assert.EqualError(t, (&Rule{Pattern: "*.mk4"}).Validate(), "JBOV rule \"*.mk4\" requires nothing, it needs a number of copies or a volume")
+}
+
+func TestRequirement_copyInEveryVolume(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Rules = []Rule{{Pattern: "*.mk4", Ncopies: ALL_VOLUMES}}
+
+	ncopies, _ := jbov.Requirement("film.mk4", 0, 0)
+	assert.Equal(t, 2, ncopies)
+
+	jbov.Volumes["vol3"] = &Volume{Uniqid: GenerateVolumeUniqId(), LastMountPoint: "/mnt/vol3"}
+	ncopies, _ = jbov.Requirement("film.mk4", 0, 0)
+	assert.Equal(t, 3, ncopies)
+
+	jbov.Volumes["vol1"].Deprecated = true
+	ncopies, _ = jbov.Requirement("film.mk4", 0, 0)
+	assert.Equal(t, 2, ncopies)
+}
+
+func TestReplication(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Volumes["vol3"] = &Volume{Uniqid: GenerateVolumeUniqId(), LastMountPoint: "/mnt/vol3", Deprecated: true}
+	jbov.Rules = []Rule{{Pattern: "*.mk4", Ncopies: 2}, {Pattern: "*.mk4", AtLeastACopyIn: "vol2"}}
+
+	ncopies, copies, missingIn := jbov.Replication("film.mk4", 0, 0, []string{"vol1", "vol3"})
+	assert.Equal(t, 2, ncopies)
+	assert.Equal(t, 1, copies)
+	assert.Equal(t, []string{"vol2"}, missingIn)
+
+	ncopies, copies, missingIn = jbov.Replication("film.mk4", 0, 0, []string{"vol1", "vol2"})
+	assert.Equal(t, 2, copies)
+	assert.Empty(t, missingIn)
+}
+
+func TestCopies_json(t *testing.T) {
+	jsonb, _ := json.Marshal([]Rule{{Pattern: "*.mk4", Ncopies: ALL_VOLUMES}, {Pattern: "*.txt", Ncopies: 2}})
+	assert.JSONEq(t, `[{"pattern": "*.mk4", "ncopies": "*"}, {"pattern": "*.txt", "ncopies": 2}]`, string(jsonb))
+
+	rules := []Rule{}
+	assert.NoError(t, json.Unmarshal(jsonb, &rules))
+	assert.Equal(t, ALL_VOLUMES, rules[0].Ncopies)
+	assert.Equal(t, Copies(2), rules[1].Ncopies)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"ncopies": -1}`), &Rule{}))
+	assert.Error(t, json.Unmarshal([]byte(`{"ncopies": "all"}`), &Rule{}))
+}
+
+func TestParseCopies(t *testing.T) {
+	copies, err := ParseCopies("*")
+	assert.NoError(t, err)
+	assert.Equal(t, ALL_VOLUMES, copies)
+	copies, err = ParseCopies("3")
+	assert.NoError(t, err)
+	assert.Equal(t, Copies(3), copies)
+	_, err = ParseCopies("-1")
+	assert.EqualError(t, err, "invalid number of copies \"-1\", expected a number or *")
+}
+
+func TestRequirement_higherPriorityOverrides(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Rules = []Rule{
+		{Pattern: "films/", Ncopies: 3},
+		{Pattern: "films/ *.tmp", Ncopies: 1, Priority: 1},
+		{Pattern: "*.tmp", AtLeastACopyIn: "vol2", Priority: 1},
+		{Pattern: "*.tmp", Ncopies: 5, Priority: -1},
+	}
+
+	assert.Equal(t, []int{1, 2}, jbov.Governing("films/film.tmp", 0, 0))
+	ncopies, in := jbov.Requirement("films/film.tmp", 0, 0)
+	assert.Equal(t, 1, ncopies)
+	assert.Equal(t, []string{"vol2"}, in)
+
+	assert.Equal(t, []int{0}, jbov.Governing("films/film.mk4", 0, 0))
+	assert.Equal(t, []int{2}, jbov.Governing("notes.tmp", 0, 0))
+	assert.Empty(t, jbov.Governing("notes.txt", 0, 0))
+}
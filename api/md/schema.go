@@ -0,0 +1,105 @@
+package md
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SCHEMA_VERSION is the version of the metadata format written by this version of jbov. Metadata files without a
+// schema version are version 1, the format used before versioning was introduced. It is bumped only by incompatible
+// changes; optional fields are added without bumping it, older versions refuse them as unknown fields only when used.
+const SCHEMA_VERSION = 2
+
+type LoadErrorKind int
+
+const (
+	LOAD_BAD_JSON            LoadErrorKind = iota
+	LOAD_UNSUPPORTED_VERSION
+	LOAD_UNKNOWN_FIELD
+	LOAD_INVALID
+)
+
+// LoadError is returned by Load, its Kind tells why the metadata could not be loaded.
+type LoadError struct {
+	Kind  LoadErrorKind
+	Cause error
+}
+
+func (err *LoadError) Error() string {
+	switch err.Kind {
+	case LOAD_BAD_JSON:
+		return "JBOV metadata is not valid JSON: " + err.Cause.Error()
+	case LOAD_UNSUPPORTED_VERSION:
+		return "JBOV metadata schema version is not supported: " + err.Cause.Error()
+	case LOAD_UNKNOWN_FIELD:
+		return "JBOV metadata has unknown fields: " + err.Cause.Error()
+	}
+	return "JBOV metadata is not valid: " + err.Cause.Error()
+}
+
+// migrations upgrade the metadata, as generic JSON, from the version they are indexed by to the next one.
+var migrations = map[int]func(map[string]interface{}){
+	1: migrateV1ToV2,
+}
+
+// migrateV1ToV2 adds the generation counter and last modified timestamp, unversioned metadata is the first generation.
+func migrateV1ToV2(raw map[string]interface{}) {
+	if _, ok := raw["generation"]; !ok {
+		raw["generation"] = 1
+	}
+	if _, ok := raw["last-modified"]; !ok {
+		raw["last-modified"] = 0
+	}
+}
+
+// Load parses and validates marshaled metadata, upgrading it to the current schema version if needed; in which case
+// migrated is true and it should be written back. Unknown fields are not accepted.
+func Load(jsonbytes []byte) (jbov *JBOV, migrated bool, err error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonbytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, false, &LoadError{LOAD_BAD_JSON, err}
+	}
+	if decoder.More() {
+		return nil, false, &LoadError{LOAD_BAD_JSON, errors.New("trailing data after metadata")}
+	}
+
+	version := 1
+	if value, ok := raw["schema-version"]; ok {
+		number, isNumber := value.(json.Number)
+		parsed, err := number.Int64()
+		if !isNumber || err != nil {
+			return nil, false, &LoadError{LOAD_UNSUPPORTED_VERSION, errors.New(fmt.Sprintf("%v", value))}
+		}
+		version = int(parsed)
+	}
+	if version < 1 || version > SCHEMA_VERSION {
+		return nil, false, &LoadError{LOAD_UNSUPPORTED_VERSION, errors.New(fmt.Sprintf("%d", version))}
+	}
+	for ; version < SCHEMA_VERSION; version++ {
+		migrations[version](raw)
+		migrated = true
+	}
+	raw["schema-version"] = SCHEMA_VERSION
+
+	upgraded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false, &LoadError{LOAD_BAD_JSON, err}
+	}
+	jbov = &JBOV{}
+	decoder = json.NewDecoder(bytes.NewReader(upgraded))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(jbov); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, false, &LoadError{LOAD_BAD_JSON, err}
+		}
+		return nil, false, &LoadError{LOAD_UNKNOWN_FIELD, err}
+	}
+	if ok, err := jbov.IsValid(); !ok {
+		return nil, false, &LoadError{LOAD_INVALID, err}
+	}
+	return jbov, migrated, nil
+}
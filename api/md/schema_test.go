@@ -0,0 +1,182 @@
+package md
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// historical formats
+
+func TestLoad_version1(t *testing.T) {
+	jbov, migrated, err := Load([]byte(givenAVersion1Json()))
+
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+	assert.Equal(t, SCHEMA_VERSION, jbov.SchemaVersion)
+	assert.Equal(t, uint64(1), jbov.Generation)
+	assert.Equal(t, "VOL:1111111111111111111111111111111111111111", jbov.Volumes["vol1"].Uniqid)
+	assert.True(t, jbov.Volumes["vol2"].Deprecated)
+	assert.Equal(t, []Rule{{Pattern: "*.mk4", Ncopies: 1}}, jbov.Rules)
+	assert.Equal(t, []string{"vol1"}, jbov.Deleted["path/to/file"].Pending)
+}
+
+func TestLoad_version2(t *testing.T) {
+	jbov, migrated, err := Load([]byte(givenAVersion2Json()))
+
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+	assert.Equal(t, uint64(7), jbov.Generation)
+	assert.Equal(t, "EDIT:3333333333333333333333333333333333333333", jbov.Head())
+	assert.Len(t, jbov.Volumes, 2)
+	assert.Equal(t, "sha256", jbov.Hashing())
+	assert.Equal(t, TrashRetention{MaxAgeDays: DEFAULT_TRASH_MAX_AGE_DAYS}, jbov.TrashRetention())
+}
+
+func TestLoad_version2WithItsOptionalFields(t *testing.T) {
+	jbov, migrated, err := Load([]byte(givenAVersion2WithOptionalFieldsJson()))
+
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+	assert.Equal(t, "hmac-sha256:00", jbov.Signature)
+	assert.Equal(t, "fsid:0000000100000002", jbov.Volumes["vol1"].Filesystem)
+	assert.Equal(t, "blake3", jbov.Hashing())
+	assert.Equal(t, TrashRetention{MaxAgeDays: 7}, jbov.TrashRetention())
+	assert.Equal(t, ALL_VOLUMES, jbov.Rules[0].Ncopies)
+	assert.Equal(t, 2, jbov.Rules[1].Priority)
+	assert.Equal(t, int64(4), jbov.Deleted["path/to/file"].Size)
+	assert.Equal(t, "sha256:00", jbov.Deleted["path/to/file"].Hash)
+	assert.Equal(t, uint64(7), jbov.History[0].Generation)
+}
+
+func TestLoad_roundTrip(t *testing.T) {
+	jbov := givenValidJBOV()
+	jbov.Touch()
+	content, _ := jbov.Marshal()
+
+	loaded, migrated, err := Load(content)
+
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+	assert.Equal(t, &jbov, loaded)
+}
+
+// errors
+
+func TestLoad_badJson(t *testing.T) {
+	_, _, err := Load([]byte("{ \"cname\": "))
+
+	assert.IsType(t, &LoadError{}, err)
+	assert.Equal(t, LOAD_BAD_JSON, err.(*LoadError).Kind)
+}
+
+func TestLoad_badJsonType(t *testing.T) {
+	_, _, err := Load([]byte(`{ "schema-version": 2, "cname": 12 }`))
+
+	assert.Equal(t, LOAD_BAD_JSON, err.(*LoadError).Kind)
+}
+
+func TestLoad_unknownField(t *testing.T) {
+	_, _, err := Load([]byte(`{ "schema-version": 2, "cname": "valid", "colour": "blue" }`))
+
+	assert.Equal(t, LOAD_UNKNOWN_FIELD, err.(*LoadError).Kind)
+	assert.EqualError(t, err, "JBOV metadata has unknown fields: json: unknown field \"colour\"")
+}
+
+func TestLoad_unsupportedVersion(t *testing.T) {
+	_, _, err := Load([]byte(`{ "schema-version": 999, "cname": "valid" }`))
+
+	assert.Equal(t, LOAD_UNSUPPORTED_VERSION, err.(*LoadError).Kind)
+	assert.EqualError(t, err, "JBOV metadata schema version is not supported: 999")
+}
+
+func TestLoad_invalid(t *testing.T) {
+	_, _, err := Load([]byte(`{ "schema-version": 2, "cname": "INVALID" }`))
+
+	assert.Equal(t, LOAD_INVALID, err.(*LoadError).Kind)
+	assert.EqualError(t, err, "JBOV metadata is not valid: JBOV cname is not valid")
+}
+
+// utils
+
+// givenAVersion1Json is the metadata as written before the schema was versioned, it must always load.
+func givenAVersion1Json() string {
+	return `{
+		"cname": "valid",
+		"uniqid": "JBOV:0000000000000000000000000000000000000000",
+		"last-mount-point": "",
+		"volumes": {
+			"vol1": {
+				"uniqid": "VOL:1111111111111111111111111111111111111111",
+				"last-mount-point": "/mnt/vol1"
+			},
+			"vol2": {
+				"uniqid": "VOL:2222222222222222222222222222222222222222",
+				"last-mount-point": "/mnt/vol2",
+				"deprecated": true
+			}
+		},
+		"rules": [
+			{ "pattern": "*.mk4", "ncopies": 1 }
+		],
+		"deleted": {
+			"path/to/file": { "ts": 1, "pending": [ "vol1" ] }
+		}
+	}`
+}
+
+func givenAVersion2Json() string {
+	return `{
+		"schema-version": 2,
+		"cname": "valid",
+		"uniqid": "JBOV:0000000000000000000000000000000000000000",
+		"last-mount-point": "",
+		"generation": 7,
+		"last-modified": 1500000000,
+		"volumes": {
+			"vol1": {
+				"uniqid": "VOL:1111111111111111111111111111111111111111",
+				"last-mount-point": "/mnt/vol1"
+			},
+			"vol2": {
+				"uniqid": "VOL:2222222222222222222222222222222222222222",
+				"last-mount-point": "/mnt/vol2"
+			}
+		},
+		"history": [
+			{ "id": "EDIT:3333333333333333333333333333333333333333", "host": "host", "ts": 1500000000 }
+		]
+	}`
+}
+
+// givenAVersion2WithOptionalFieldsJson is version 2 metadata using the optional fields added since, which need no
+// schema version bump.
+func givenAVersion2WithOptionalFieldsJson() string {
+	return `{
+		"schema-version": 2,
+		"cname": "valid",
+		"uniqid": "JBOV:0000000000000000000000000000000000000000",
+		"last-mount-point": "",
+		"generation": 7,
+		"last-modified": 1500000000,
+		"volumes": {
+			"vol1": {
+				"uniqid": "VOL:1111111111111111111111111111111111111111",
+				"last-mount-point": "/mnt/vol1",
+				"filesystem": "fsid:0000000100000002"
+			}
+		},
+		"rules": [
+			{ "pattern": "*.mk4", "ncopies": "*" },
+			{ "pattern": "*.txt", "ncopies": 1, "priority": 2 }
+		],
+		"deleted": {
+			"path/to/file": { "ts": 1, "pending": [ "vol1" ], "size": 4, "hash": "sha256:00" }
+		},
+		"history": [
+			{ "id": "EDIT:3333333333333333333333333333333333333333", "host": "host", "ts": 1500000000, "generation": 7 }
+		],
+		"hash-algorithm": "blake3",
+		"trash": { "max-age-days": 7 },
+		"signature": "hmac-sha256:00"
+	}`
+}
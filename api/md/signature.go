@@ -0,0 +1,65 @@
+package md
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+const SIGNATURE_PREFIX = "hmac-sha256:"
+
+// Sign sets the metadata signature, an HMAC of the metadata as marshaled (without signature) keyed by the JBOV key.
+func (jbov *JBOV) Sign(key []byte) error {
+	jbov.Signature = ""
+	jsonb, err := jbov.Marshal()
+	if err != nil {
+		return err
+	}
+	signature, err := Signature(jsonb, key)
+	if err != nil {
+		return err
+	}
+	jbov.Signature = signature
+	return nil
+}
+
+// VerifySignature tells if the metadata has been signed with the given key and has not been modified since. Metadata
+// read from a volume is verified as read with VerifyRawSignature, once loaded it might have been migrated.
+func (jbov *JBOV) VerifySignature(key []byte) bool {
+	jsonb, err := jbov.Marshal()
+	return err == nil && VerifyRawSignature(jsonb, key)
+}
+
+// VerifyRawSignature tells if marshaled metadata, as read and in any schema version, holds a signature made with the
+// given key and has not been modified since.
+func VerifyRawSignature(jsonbytes []byte, key []byte) bool {
+	var raw struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(jsonbytes, &raw); err != nil || raw.Signature == "" {
+		return false
+	}
+	expected, err := Signature(jsonbytes, key)
+	return err == nil && hmac.Equal([]byte(expected), []byte(raw.Signature))
+}
+
+// Signature returns the signature of marshaled metadata, ignoring its signature field. It is computed over the
+// metadata in canonical form (sorted keys, no spacing), so it does not depend on how it was indented.
+func Signature(jsonbytes []byte, key []byte) (string, error) {
+	var document map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonbytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return "", err
+	}
+	delete(document, "signature")
+	canonical, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(canonical)
+	return fmt.Sprintf("%s%x", SIGNATURE_PREFIX, mac.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+package md
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign_verifies(t *testing.T) {
+	jbov := givenValidJBOV()
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	assert.NoError(t, jbov.Sign(key))
+
+	assert.True(t, jbov.VerifySignature(key))
+	assert.False(t, jbov.VerifySignature([]byte("another key")))
+}
+
+func TestSign_modifiedMetadataDoesNotVerify(t *testing.T) {
+	jbov := givenValidJBOV()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	jbov.Sign(key)
+
+	jbov.Rules = append(jbov.Rules, Rule{Pattern: "*", Ncopies: 3})
+
+	assert.False(t, jbov.VerifySignature(key))
+}
+
+func TestVerifySignature_unsignedDoesNotVerify(t *testing.T) {
+	jbov := givenValidJBOV()
+
+	assert.False(t, jbov.VerifySignature([]byte("key")))
+}
+
+func TestVerifyRawSignature_olderSchemaVersion(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	raw := givenSigned(givenAVersion1Json(), key)
+
+	jbov, migrated, err := Load(raw)
+
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+	assert.True(t, VerifyRawSignature(raw, key))
+	assert.False(t, VerifyRawSignature(raw, []byte("another key")))
+	assert.False(t, VerifyRawSignature(bytes.Replace(raw, []byte("*.mk4"), []byte("*.txt"), 1), key))
+
+	assert.NoError(t, jbov.Sign(key))
+	jsonb, _ := jbov.Marshal()
+	assert.True(t, VerifyRawSignature(jsonb, key))
+}
+
+// utility
+
+func givenSigned(jsonString string, key []byte) []byte {
+	var document map[string]interface{}
+	json.Unmarshal([]byte(jsonString), &document)
+	document["signature"], _ = Signature([]byte(jsonString), key)
+	jsonb, _ := json.MarshalIndent(document, "", "  ")
+	return jsonb
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kuking/jbov/api/md"
+)
+
+// MergeForks opens the JBOV at path and merges the metadata copies edited independently in its volumes, using the
+// metadata as it was at their last common edit as base. The copy held by the volume at path is taken as "ours". When
+// the merge has conflicts nothing is written unless force is given, in which case ours is kept for every conflict.
+func MergeForks(path string, force bool) (*Handle, []md.Conflict, error) {
+	handle, opened, err := load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lock, err := handle.Lock("metadata merge")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lock.Release()
+
+	copies := handle.readCopies()
+	for _, copy := range copies {
+		if copy.forged {
+			return nil, nil, &SignatureError{Cname: copy.cname, MountPoint: handle.Volumes[copy.cname].MountPoint}
+		}
+	}
+	tips := forkedTips(copies)
+	if len(tips) < 2 {
+		return nil, nil, errors.New("JBOV metadata has not been forked, there is nothing to merge")
+	}
+	ours := handle.Jbov
+	for _, tip := range tips {
+		if tip.jbov.Descends(ours) {
+			ours = tip.jbov
+		}
+	}
+
+	conflicts := []md.Conflict{}
+	merged := ours
+	for _, tip := range tips {
+		if merged.Descends(tip.jbov) {
+			continue
+		}
+		base := handle.readSnapshot(merged.CommonAncestor(tip.jbov))
+		var tipConflicts []md.Conflict
+		merged, tipConflicts = md.Merge(base, merged, tip.jbov)
+		conflicts = append(conflicts, tipConflicts...)
+	}
+	if len(conflicts) > 0 && !force {
+		return nil, conflicts, errors.New(fmt.Sprintf("JBOV metadata merge has %d conflict(s)", len(conflicts)))
+	}
+	if ok, err := merged.IsValid(); !ok {
+		return nil, conflicts, errors.New(fmt.Sprintf("Merged JBOV metadata is not valid: %s", err.Error()))
+	}
+
+	handle.Jbov = merged
+	if _, err := handle.resolve(opened.uniqid, opened.path); err != nil {
+		return nil, conflicts, err
+	}
+	if err := handle.writeOnto(handle.Present()); err != nil {
+		return nil, conflicts, err
+	}
+	return handle, conflicts, nil
+}
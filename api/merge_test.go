@@ -0,0 +1,90 @@
+package api
+
+import (
+	"testing"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen_failsWithSplitBrainWhenCopiesWereEditedIndependently(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenForkedCopies(&jbov, md.Rule{Pattern: "*.jpg", Ncopies: 2}, md.Rule{Pattern: "*.avi", Ncopies: 1})
+
+	_, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.IsType(t, &SplitBrainError{}, err)
+	assert.Len(t, err.(*SplitBrainError).Heads, 2)
+	assert.Contains(t, err.Error(), "[vol1] and [vol2]")
+}
+
+func TestOpen_volumeOfflineForLongerThanTheHistoryIsNoFork(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	for i := 0; i <= md.MAX_HISTORY; i++ {
+		jbov.Touch()
+	}
+	writeMetadata(&jbov, []string{jbov.Volumes["vol1"].LastMountPoint}, nil)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, handle.Divergent)
+	assert.Equal(t, jbov.Generation, readMetadataCopy(jbov.Volumes["vol2"].LastMountPoint).Generation)
+}
+
+func TestMergeForks_happyPath(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenForkedCopies(&jbov, md.Rule{Pattern: "*.jpg", Ncopies: 2}, md.Rule{Pattern: "*.avi", Ncopies: 1})
+
+	handle, conflicts, err := MergeForks(jbov.Volumes["vol1"].LastMountPoint, false)
+
+	assert.NoError(t, err)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, []md.Rule{{Pattern: "*.jpg", Ncopies: 2}, {Pattern: "*.avi", Ncopies: 1}}, handle.Jbov.Rules)
+
+	handle, err = Open(jbov.Volumes["vol2"].LastMountPoint)
+	assert.NoError(t, err)
+	assert.Empty(t, handle.Divergent)
+	assert.Len(t, handle.Jbov.Rules, 2)
+}
+
+func TestMergeForks_conflictsAreNotWrittenUnlessForced(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenForkedCopies(&jbov, md.Rule{Pattern: "*.jpg", Ncopies: 2}, md.Rule{Pattern: "*.jpg", Ncopies: 1})
+
+	_, conflicts, err := MergeForks(jbov.Volumes["vol1"].LastMountPoint, false)
+
+	assert.EqualError(t, err, "JBOV metadata merge has 1 conflict(s)")
+	assert.Equal(t, []md.Conflict{{Section: "rule", Key: "*.jpg", Reason: "added with different requirements in both sides"}}, conflicts)
+	_, err = Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.IsType(t, &SplitBrainError{}, err)
+
+	_, _, err = MergeForks(jbov.Volumes["vol1"].LastMountPoint, true)
+	assert.NoError(t, err)
+	_, err = Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.NoError(t, err)
+}
+
+func TestMergeForks_failsWhenThereIsNoFork(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	_, _, err := MergeForks(jbov.Volumes["vol1"].LastMountPoint, false)
+
+	assert.EqualError(t, err, "JBOV metadata has not been forked, there is nothing to merge")
+}
+
+// utility
+
+func givenForkedCopies(jbov *md.JBOV, oursRule md.Rule, theirsRule md.Rule) {
+	for cname, rule := range map[string]md.Rule{"vol1": oursRule, "vol2": theirsRule} {
+		jsonb, _ := jbov.Marshal()
+		fork, _, _ := md.Load(jsonb)
+		fork.Rules = append(fork.Rules, rule)
+		fork.Touch()
+		writeMetadata(fork, []string{jbov.Volumes[cname].LastMountPoint}, nil)
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"github.com/kuking/jbov/api/md"
+)
+
+type VolumeState int
+
+const (
+	VOLUME_PRESENT    VolumeState = iota
+	VOLUME_MISSING
+	VOLUME_MISMATCHED
+)
+
+func (state VolumeState) String() string {
+	switch state {
+	case VOLUME_PRESENT:
+		return "present"
+	case VOLUME_MISSING:
+		return "missing"
+	case VOLUME_MISMATCHED:
+		return "mismatched"
+	}
+	return "unknown"
+}
+
+type VolumeStatus struct {
+	Cname      string
+	MountPoint string
+	State      VolumeState
+}
+
+type Handle struct {
+	Jbov      *md.JBOV
+	Volumes   map[string]*VolumeStatus
+	Divergent []string
+	Problems  []Problem
+	Warnings  []Problem
+	relocated bool
+	key       []byte
+	opened    *openedVolume
+	lock      *Lock
+}
+
+// Open loads a JBOV out of any of its member volumes mount point, the sibling volumes are located by their last known
+// mount point and checked to be the expected ones. The metadata copies found in every present volume are reconciled,
+// the newest one wins and is written back onto the lagging volumes. Copies edited independently are not reconciled, a
+// SplitBrainError is returned instead. Deletions still pending in the volumes back are applied. All of these changes
+// are done holding the lock, they are left for the next time when somebody else holds it.
+func Open(path string) (*Handle, error) {
+	handle, opened, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	handle.opened = opened
+	if err := handle.adopt(opened.raw); err != nil {
+		return nil, err
+	}
+
+	lock, err := handle.Lock("open")
+	if err != nil {
+		// somebody else is changing the metadata, it will be reconciled next time
+		return handle, nil
+	}
+	defer lock.Release()
+	handle.removeStagedFiles()
+	handle.recoverJournal()
+	if handle.relocated {
+		if err := handle.Save(); err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not update relocated volumes mount points: %s", err.Error()))
+		}
+	} else if err := handle.writeOnto(handle.Divergent); err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not update stale metadata copies: %s", err.Error()))
+	}
+	if err := handle.applyTombstones(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not apply pending deletions: %s", err.Error()))
+	}
+	return handle, nil
+}
+
+// adopt reconciles the in-memory metadata, as marshaled in raw, with the copies held by the present volumes; the newest
+// one wins and the copies differing from it are recorded as divergent.
+func (handle *Handle) adopt(raw []byte) error {
+	winner, divergent, err := handle.reconcile(raw)
+	if err != nil {
+		return err
+	}
+	if winner != handle.Jbov {
+		handle.Jbov = winner
+		if handle.relocated, err = handle.resolve(handle.opened.uniqid, handle.opened.path); err != nil {
+			return err
+		}
+	}
+	handle.Divergent = divergent
+	return nil
+}
+
+// refresh reconciles the in-memory metadata with the copies held by the present volumes again, so the changes made by
+// others since it was read are not lost; it is done once locked. Handles not opened out of the volumes, i.e. restoring
+// a snapshot, are left as they are.
+func (handle *Handle) refresh() error {
+	if handle.opened == nil {
+		return nil
+	}
+	raw, err := handle.Jbov.Marshal()
+	if err != nil {
+		return err
+	}
+	return handle.adopt(raw)
+}
+
+type openedVolume struct {
+	path   string
+	uniqid string
+	raw    []byte
+}
+
+// load reads the metadata held in the volume at path and resolves the state of its sibling volumes.
+func load(path string) (*Handle, *openedVolume, error) {
+	path, err := filepath.Abs(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jsonb, err := ioutil.ReadFile(filepath.Join(path, md.JBOV_FNAME))
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Could not read JBOV metadata in \"%s\": %s", path, err.Error()))
+	}
+	uniqid, err := ioutil.ReadFile(filepath.Join(path, md.UNIQID_FNAME))
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Could not read volume uniqid in \"%s\": %s", path, err.Error()))
+	}
+
+	jbov, _, err := md.Load(jsonb)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Could not load JBOV metadata in \"%s\": %s", path, err.Error()))
+	}
+
+	opened := openedVolume{path: path, uniqid: string(uniqid), raw: jsonb}
+	handle := Handle{Jbov: jbov}
+	if handle.key, err = ReadKey(jbov.Uniqid); err != nil {
+		return nil, nil, err
+	}
+	if handle.key != nil && !md.VerifyRawSignature(jsonb, handle.key) {
+		cname := ""
+		for volCname, vol := range jbov.Volumes {
+			if vol.Uniqid == opened.uniqid {
+				cname = volCname
+			}
+		}
+		return nil, nil, &SignatureError{Cname: cname, MountPoint: path}
+	}
+	if handle.relocated, err = handle.resolve(opened.uniqid, opened.path); err != nil {
+		return nil, nil, err
+	}
+	return &handle, &opened, nil
+}
+
+// resolve finds out the state of every volume in the metadata and checks their consistency, the volume opened by path
+// is identified by its uniqid. It returns true when any volume has been relocated.
+func (handle *Handle) resolve(openedUniqid string, path string) (bool, error) {
+	opened := ""
+	for cname, vol := range handle.Jbov.Volumes {
+		if vol.Uniqid == openedUniqid {
+			opened = cname
+		}
+	}
+	if opened == "" {
+		return false, errors.New(fmt.Sprintf("Volume in \"%s\" is not part of JBOV \"%s\"", path, handle.Jbov.Cname))
+	}
+
+	handle.Volumes = make(map[string]*VolumeStatus)
+	for cname, vol := range handle.Jbov.Volumes {
+		mountPoint := vol.LastMountPoint
+		if cname == opened {
+			mountPoint = path
+		}
+		handle.Volumes[cname] = &VolumeStatus{Cname: cname, MountPoint: mountPoint, State: volumeState(mountPoint, vol)}
+	}
+	relocated := handle.relocate()
+	handle.Problems = handle.CheckConsistency()
+	handle.Warnings = append(append(handle.checkFilesystems(), handle.checkRules()...), handle.checkSignature()...)
+	return relocated, nil
+}
+
+func volumeState(mountPoint string, vol *md.Volume) VolumeState {
+	uniqid, err := ioutil.ReadFile(filepath.Join(mountPoint, md.UNIQID_FNAME))
+	if os.IsNotExist(err) {
+		return VOLUME_MISSING
+	}
+	if err != nil || string(uniqid) != vol.Uniqid {
+		return VOLUME_MISMATCHED
+	}
+	return VOLUME_PRESENT
+}
+
+// Present returns the cnames of the volumes found in their expected mount point, sorted.
+func (handle *Handle) Present() []string {
+	return handle.inState(VOLUME_PRESENT)
+}
+
+// Missing returns the cnames of the volumes not found, sorted.
+func (handle *Handle) Missing() []string {
+	return handle.inState(VOLUME_MISSING)
+}
+
+// Mismatched returns the cnames of the volumes whose mount point holds a different volume, sorted.
+func (handle *Handle) Mismatched() []string {
+	return handle.inState(VOLUME_MISMATCHED)
+}
+
+func (handle *Handle) inState(state VolumeState) []string {
+	cnames := []string{}
+	for cname, status := range handle.Volumes {
+		if status.State == state {
+			cnames = append(cnames, cname)
+		}
+	}
+	sort.Strings(cnames)
+	return cnames
+}
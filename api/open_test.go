@@ -0,0 +1,95 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+	"io/ioutil"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen_happyPath(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	handle, err := Open(jbov.Volumes["vol2"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, jbov.Uniqid, handle.Jbov.Uniqid)
+	assert.Equal(t, []string{"vol1", "vol2"}, handle.Present())
+	assert.Empty(t, handle.Missing())
+	assert.Empty(t, handle.Mismatched())
+}
+
+func TestOpen_reportsMissingVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	os.RemoveAll(jbov.Volumes["vol2"].LastMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1"}, handle.Present())
+	assert.Equal(t, []string{"vol2"}, handle.Missing())
+}
+
+func TestOpen_reportsMismatchedVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, md.UNIQID_FNAME), []byte(md.GenerateVolumeUniqId()), 0644)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, handle.Mismatched())
+}
+
+func TestOpen_failsWhenThereIsNoMetadata(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "")
+	defer os.RemoveAll(dir)
+
+	_, err := Open(dir)
+
+	assert.Error(t, err)
+}
+
+func TestOpen_failsWhenVolumeIsNotPartOfTheJBOV(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, md.UNIQID_FNAME), []byte(md.GenerateVolumeUniqId()), 0644)
+
+	_, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.EqualError(t, err, "Volume in \""+jbov.Volumes["vol1"].LastMountPoint+"\" is not part of JBOV \"valid\"")
+}
+
+func TestOpen_migratesOlderMetadataInPlace(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	for _, vol := range jbov.Volumes {
+		jsonb := []byte(`{ "cname": "valid", "uniqid": "` + jbov.Uniqid + `", "last-mount-point": "", "volumes": {` +
+			`"vol1": { "uniqid": "` + jbov.Volumes["vol1"].Uniqid + `", "last-mount-point": "` + jbov.Volumes["vol1"].LastMountPoint + `" },` +
+			`"vol2": { "uniqid": "` + jbov.Volumes["vol2"].Uniqid + `", "last-mount-point": "` + jbov.Volumes["vol2"].LastMountPoint + `" } } }`)
+		ioutil.WriteFile(filepath.Join(vol.LastMountPoint, md.JBOV_FNAME), jsonb, 0644)
+	}
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1", "vol2"}, handle.Divergent)
+	for _, vol := range jbov.Volumes {
+		assert.Equal(t, md.SCHEMA_VERSION, readMetadataCopy(vol.LastMountPoint).SchemaVersion)
+	}
+}
+
+// utility
+
+func givenCreatedJBOV(t *testing.T) md.JBOV {
+	jbov := givenValidJBOV()
+	givenMountPointsExist(&jbov)
+	if _, err := Create(&jbov); err != nil {
+		t.Fatal(err)
+	}
+	return jbov
+}
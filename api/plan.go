@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"github.com/kuking/jbov/api/catalog"
+)
+
+const PLAN_FORMAT = 1
+
+type OpKind string
+
+const (
+	OP_COPY          OpKind = "copy"
+	OP_MOVE          OpKind = "move"
+	OP_DELETE        OpKind = "delete"
+	OP_REPAIR        OpKind = "repair"
+	OP_REMOVE_VOLUME OpKind = "remove-volume"
+)
+
+// Op is a change to the volumes content: a file copied or moved from the source volume to the target one, deleted
+// from the target one or repaired in the target one from the source one; or the target volume removed from the JBOV.
+// Hash is the content expected, copies not matching it are discarded.
+type Op struct {
+	Kind   OpKind `json:"op"`
+	Path   string `json:"path,omitempty"`
+	Source string `json:"source,omitempty"`
+	Target string `json:"target"`
+	Size   int64 `json:"size,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (op Op) String() string {
+	switch op.Kind {
+	case OP_COPY, OP_MOVE:
+		return fmt.Sprintf("%s %s from volume \"%s\" to \"%s\" (%s)", op.Kind, op.Path, op.Source, op.Target, op.Reason)
+	case OP_REPAIR:
+		return fmt.Sprintf("%s %s in volume \"%s\" from volume \"%s\" (%s)", op.Kind, op.Path, op.Target, op.Source, op.Reason)
+	case OP_DELETE:
+		return fmt.Sprintf("%s %s from volume \"%s\" (%s)", op.Kind, op.Path, op.Target, op.Reason)
+	}
+	return fmt.Sprintf("%s \"%s\" (%s)", op.Kind, op.Target, op.Reason)
+}
+
+// VolumeUsage is how a plan changes a volume: the bytes it adds and removes, and the free space left afterwards
+// (unknown, -1, when the platform does not tell).
+type VolumeUsage struct {
+	Added   int64 `json:"added"`
+	Removed int64 `json:"removed"`
+	Free    int64 `json:"free"`
+}
+
+// Plan is the list of changes a mutating command would do, it can be saved, reviewed and applied later on. It records
+// the catalog of every volume when planned (by fingerprint) as it can only be applied onto the same files.
+type Plan struct {
+	Format   int `json:"format"`
+	Jbov     string `json:"jbov"`
+	Command  string `json:"command"`
+	Created  int64 `json:"created"`
+	Ops      []Op `json:"ops"`
+	Findings []Finding `json:"findings"`
+	Catalogs map[string]string `json:"catalogs"`
+	Volumes  map[string]*VolumeUsage `json:"volumes"`
+}
+
+func (handle *Handle) newPlan(command string, catalogs map[string]*catalog.Catalog) *Plan {
+	plan := Plan{
+		Format:   PLAN_FORMAT,
+		Jbov:     handle.Jbov.Uniqid,
+		Command:  command,
+		Created:  time.Now().Unix(),
+		Ops:      []Op{},
+		Findings: []Finding{},
+		Catalogs: make(map[string]string),
+	}
+	for cname, volCatalog := range catalogs {
+		plan.Catalogs[cname] = volCatalog.Fingerprint()
+	}
+	return &plan
+}
+
+func (plan *Plan) add(op Op) {
+	plan.Ops = append(plan.Ops, op)
+}
+
+func (plan *Plan) finding(finding Finding) {
+	plan.Findings = append(plan.Findings, finding)
+}
+
+// HasErrors tells whether any finding of the plan is an error, such plans should not be applied.
+func (plan *Plan) HasErrors() bool {
+	for _, finding := range plan.Findings {
+		if finding.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes returns the amount of data to be written.
+func (plan *Plan) Bytes() int64 {
+	bytes := int64(0)
+	for _, op := range plan.Ops {
+		if op.Kind == OP_COPY || op.Kind == OP_MOVE || op.Kind == OP_REPAIR {
+			bytes += op.Size
+		}
+	}
+	return bytes
+}
+
+// Cnames returns the volumes changed by the plan, sorted.
+func (plan *Plan) Cnames() []string {
+	cnames := []string{}
+	for cname := range plan.Volumes {
+		cnames = append(cnames, cname)
+	}
+	sort.Strings(cnames)
+	return cnames
+}
+
+// account works out the bytes added and removed in every volume, and the free space they would be left with.
+func (handle *Handle) account(plan *Plan) {
+	plan.Volumes = make(map[string]*VolumeUsage)
+	usage := func(cname string) *VolumeUsage {
+		if plan.Volumes[cname] == nil {
+			plan.Volumes[cname] = &VolumeUsage{}
+		}
+		return plan.Volumes[cname]
+	}
+	for _, op := range plan.Ops {
+		switch op.Kind {
+		case OP_COPY, OP_REPAIR:
+			usage(op.Target).Added += op.Size
+		case OP_MOVE:
+			usage(op.Target).Added += op.Size
+			usage(op.Source).Removed += op.Size
+		case OP_DELETE:
+			usage(op.Target).Removed += op.Size
+		}
+	}
+	for cname, volume := range plan.Volumes {
+		volume.Free = -1
+		if status, ok := handle.Volumes[cname]; ok {
+			if free, err := freeSpace(status.MountPoint); err == nil {
+				volume.Free = free - volume.Added + volume.Removed
+			}
+		}
+	}
+}
+
+// Save writes the plan as JSON, to be applied later on.
+func (plan *Plan) Save(path string) error {
+	jsonb, err := json.MarshalIndent(plan, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, jsonb, 0644)
+}
+
+// ReadPlan reads a plan saved with Plan.Save.
+func ReadPlan(path string) (*Plan, error) {
+	jsonb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(jsonb, &plan); err != nil {
+		return nil, errors.New(fmt.Sprintf("Plan \"%s\" is not valid: %s", path, err.Error()))
+	}
+	if plan.Format != PLAN_FORMAT {
+		return nil, errors.New(fmt.Sprintf("Plan \"%s\" format is not supported: %d", path, plan.Format))
+	}
+	return &plan, nil
+}
+
+// Apply executes a plan, it returns the number of operations done. It refuses to when the plan is for another JBOV or
+// when any of the volumes catalogs changed since it was planned. Every file written is staged in a temporary file and
+// verified against the hash planned before being renamed into place; and every operation is recorded in a journal, so
+// an interrupted or failed plan can be recovered and resumed.
+func (handle *Handle) Apply(plan *Plan) (int, error) {
+	if plan.Jbov != handle.Jbov.Uniqid {
+		return 0, errors.New(fmt.Sprintf("The plan is not for JBOV \"%s\"", handle.Jbov.Cname))
+	}
+	lock, err := handle.Lock(plan.Command)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return 0, err
+	}
+	for cname, fingerprint := range plan.Catalogs {
+		if volCatalog, ok := catalogs[cname]; !ok || volCatalog.Fingerprint() != fingerprint {
+			return 0, errors.New(fmt.Sprintf("Volume \"%s\" files changed since the plan was made, plan it again", cname))
+		}
+	}
+
+	return handle.run(plan, map[int]bool{}, catalogs)
+}
+
+// run applies the operations of a plan not done yet, recording them in the journal as it goes; it returns the number
+// of operations done. The journal is removed once finished, on errors it is kept so the plan can be resumed once the
+// cause is fixed (i.e. a volume unplugged).
+func (handle *Handle) run(plan *Plan, done map[int]bool, catalogs map[string]*catalog.Catalog) (int, error) {
+	j, err := handle.startJournal(plan, done)
+	if err != nil {
+		return 0, err
+	}
+
+	trashId := newTrashId()
+	count := 0
+	for i, op := range plan.Ops {
+		if done[i] {
+			continue
+		}
+		if err = j.record(journalRecord{Op: i, State: JOURNAL_BEGIN}); err != nil {
+			break
+		}
+		if err = handle.applyOp(op, trashId, catalogs); err != nil {
+			err = errors.New(fmt.Sprintf("Could not %s: %s", op, err.Error()))
+			break
+		}
+		if err = j.record(journalRecord{Op: i, State: JOURNAL_DONE}); err != nil {
+			break
+		}
+		count++
+	}
+	for cname, volCatalog := range catalogs {
+		if _, ok := handle.Jbov.Volumes[cname]; !ok {
+			continue
+		}
+		if saveErr := volCatalog.Save(handle.Volumes[cname].MountPoint); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	if _, _, purgeErr := handle.purgeTrashRetention(); purgeErr != nil && err == nil {
+		err = purgeErr
+	}
+	if err != nil {
+		j.close()
+		return count, err
+	}
+	j.finish()
+	return count, nil
+}
+
+func (handle *Handle) applyOp(op Op, trashId string, catalogs map[string]*catalog.Catalog) error {
+	if op.Kind != OP_REMOVE_VOLUME && (catalogs[op.Target] == nil || (op.Source != "" && catalogs[op.Source] == nil)) {
+		return errors.New("volume not available")
+	}
+	switch op.Kind {
+	case OP_COPY:
+		return handle.copyReplica(op, catalogs)
+	case OP_MOVE:
+		if err := handle.copyReplica(op, catalogs); err != nil {
+			return err
+		}
+		return handle.deleteReplica(op.Source, op.Path, "", catalogs)
+	case OP_DELETE:
+		return handle.deleteReplica(op.Target, op.Path, trashId, catalogs)
+	case OP_REPAIR:
+		return handle.repairReplica(op, trashId, catalogs)
+	case OP_REMOVE_VOLUME:
+		return handle.removeVolume(op.Target)
+	}
+	return errors.New(fmt.Sprintf("unknown operation \"%s\"", op.Kind))
+}
+
+// deleteReplica removes a file from a volume, as long as it has not changed since catalogued. It is moved to the trash,
+// but when there is no trash id given: a moved file, whose content is kept in the target.
+func (handle *Handle) deleteReplica(cname string, path string, trashId string, catalogs map[string]*catalog.Catalog) error {
+	fullPath := filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path))
+	entry, ok := catalogs[cname].Entries[path]
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !ok || !catalog.EntryOf(info).IsSameFileAs(entry) {
+		return errors.New(fmt.Sprintf("%s changed in volume \"%s\"", path, cname))
+	}
+	if trashId != "" {
+		if _, err := handle.trash(cname, trashId, path); err != nil {
+			return err
+		}
+	} else if err := os.Remove(fullPath); err != nil {
+		return err
+	} else if err := syncDir(filepath.Dir(fullPath)); err != nil {
+		return err
+	}
+	delete(catalogs[cname].Entries, path)
+	return nil
+}
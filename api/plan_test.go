@@ -0,0 +1,199 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan_saveAndRead(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+	plan, _ := handle.PlanSync()
+	path := filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "..", "plan.json")
+	defer os.Remove(path)
+
+	assert.NoError(t, plan.Save(path))
+	read, err := ReadPlan(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, plan, read)
+	done, err := handle.Apply(read)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, done)
+}
+
+func TestReadPlan_invalid(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "jbov-invalid-plan.json")
+	ioutil.WriteFile(path, []byte("{\"format\": 99}"), 0644)
+	defer os.Remove(path)
+
+	_, err := ReadPlan(path)
+
+	assert.EqualError(t, err, "Plan \""+path+"\" format is not supported: 99")
+}
+
+func TestApply_refusesPlansOfAnotherJBOV(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	plan, _ := handle.PlanSync()
+	plan.Jbov = md.GenerateJbovUniqId()
+
+	_, err := handle.Apply(plan)
+
+	assert.EqualError(t, err, "The plan is not for JBOV \""+jbov.Cname+"\"")
+}
+
+func TestPlanRebalance_movesFromTheFullestVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenFreeSpaceLeftByTheFiles(100)()
+	givenFileIn(&jbov, "a.bin", "0123456789", "vol1")
+	givenFileIn(&jbov, "b.bin", "01234", "vol1")
+	givenFileIn(&jbov, "c.bin", "01", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	plan, err := handle.PlanRebalance()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Op{{OP_MOVE, "b.bin", "vol1", "vol2", 5, "", "rebalance"}, {OP_MOVE, "c.bin", "vol1", "vol2", 2, "", "rebalance"}}, plan.Ops)
+	assert.Equal(t, int64(90), plan.Volumes["vol1"].Free)
+	assert.Equal(t, int64(93), plan.Volumes["vol2"].Free)
+
+	done, err := handle.Apply(plan)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, done)
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "b.bin"))
+	assert.True(t, os.IsNotExist(err))
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "b.bin"))
+	assert.Equal(t, "01234", string(content))
+}
+
+func TestPlanRebalance_keepsTheCopiesRequiredInAVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenFreeSpaceLeftByTheFiles(100)()
+	givenFileIn(&jbov, "a.bin", "0123456789", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.bin", AtLeastACopyIn: "vol1"}}
+
+	plan, err := handle.PlanRebalance()
+
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+}
+
+func TestPlanVolumeRemoval_copiesTheFilesLeftWithoutEnoughCopies(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "only.txt", "only", "vol3")
+	givenFileIn(&jbov, "both.txt", "both", "vol2", "vol3")
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol3")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+
+	plan, err := handle.PlanVolumeRemoval("vol3", false)
+
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Findings)
+	assert.Equal(t, []Op{
+		{OP_COPY, "film.mk4", "vol3", "vol2", 4, "", "volume removed"},
+		{OP_COPY, "only.txt", "vol3", "vol1", 4, "", "volume removed"},
+		{Kind: OP_REMOVE_VOLUME, Target: "vol3", Reason: "volume removed"},
+	}, plan.Ops)
+
+	_, err = handle.Apply(plan)
+
+	assert.NoError(t, err)
+	assert.NotContains(t, handle.Jbov.Volumes, "vol3")
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "only.txt"))
+	assert.Equal(t, "only", string(content))
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol3"].LastMountPoint, md.JBOV_FNAME))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol3"].LastMountPoint, "only.txt"))
+	assert.NoError(t, err)
+	reopened, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.NoError(t, err)
+	assert.Len(t, reopened.Jbov.Volumes, 2)
+}
+
+func TestPlanVolumeRemoval_refusesVolumesRequiredByRules(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", AtLeastACopyIn: "vol2"}}
+
+	_, err := handle.PlanVolumeRemoval("vol2", false)
+
+	assert.EqualError(t, err, "Volume \"vol2\" is required by the rule \"*.mk4\", delete the rule first")
+}
+
+func TestPlanVolumeRemoval_keepsTheVolumeWhenItsFilesCanNotBePreserved(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "x.txt", "ours", "vol1")
+	givenFileIn(&jbov, "x.txt", "theirs", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.ReplicaHashes("x.txt")
+
+	plan, err := handle.PlanVolumeRemoval("vol2", false)
+
+	assert.NoError(t, err)
+	assert.True(t, plan.HasErrors())
+	assert.Empty(t, plan.Ops)
+}
+
+func TestPlanVolumeRemoval_missingVolumeOnlyWhenForced(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	os.RemoveAll(jbov.Volumes["vol2"].LastMountPoint)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	_, err := handle.PlanVolumeRemoval("vol2", false)
+
+	assert.EqualError(t, err, "Volume \"vol2\" is not available, its files can not be preserved")
+
+	plan, err := handle.PlanVolumeRemoval("vol2", true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Op{{Kind: OP_REMOVE_VOLUME, Target: "vol2", Reason: "missing volume removed"}}, plan.Ops)
+	_, err = handle.Apply(plan)
+	assert.NoError(t, err)
+	reopened, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+	assert.NoError(t, err)
+	assert.NotContains(t, reopened.Jbov.Volumes, "vol2")
+}
+
+// utility
+
+// givenFreeSpaceLeftByTheFiles fakes the volumes free space as the given capacity minus the files they hold, as in
+// tests every volume is in the same filesystem.
+func givenFreeSpaceLeftByTheFiles(capacity int64) func() {
+	freeSpace = func(mountPoint string) (int64, error) {
+		used := int64(0)
+		err := filepath.Walk(mountPoint, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !strings.HasPrefix(info.Name(), ".jbov") {
+				if err == nil && info.Mode().IsRegular() {
+					used += info.Size()
+				}
+				return err
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		return capacity - used, err
+	}
+	return func() { freeSpace = freeSpaceOf }
+}
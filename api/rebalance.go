@@ -0,0 +1,99 @@
+package api
+
+import (
+	"sort"
+	"github.com/kuking/jbov/api/catalog"
+)
+
+// PlanRebalance plans moving files from the volumes with the least free space to the ones with the most, until their
+// free space is as even as the files allow. Only non-deprecated writable volumes are balanced; files are never moved
+// out of a volume their rules require a copy in, nor to a volume holding a copy already.
+func (handle *Handle) PlanRebalance() (*Plan, error) {
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, err
+	}
+	plan := handle.newPlan("rebalance", catalogs)
+	free := make(map[string]int64)
+	for cname := range catalogs {
+		if handle.Jbov.Volumes[cname].Deprecated {
+			continue
+		}
+		if free[cname], err = freeSpace(handle.Volumes[cname].MountPoint); err != nil {
+			return nil, err
+		}
+	}
+	holders := make(map[string]map[string]bool)
+	for cname, volCatalog := range catalogs {
+		for path := range volCatalog.Entries {
+			if holders[path] == nil {
+				holders[path] = make(map[string]bool)
+			}
+			holders[path][cname] = true
+		}
+	}
+
+	for moves := 0; moves < len(holders); moves++ {
+		fullest, emptiest := extremes(free)
+		if fullest == emptiest {
+			break
+		}
+		path := handle.largestMovable(catalogs[fullest], fullest, emptiest, (free[emptiest]-free[fullest])/2, holders)
+		if path == "" {
+			break
+		}
+		entry := catalogs[fullest].Entries[path]
+		plan.add(Op{OP_MOVE, path, fullest, emptiest, entry.Size, entry.Hash, "rebalance"})
+		free[fullest] += entry.Size
+		free[emptiest] -= entry.Size
+		delete(holders[path], fullest)
+		holders[path][emptiest] = true
+	}
+	handle.account(plan)
+	return plan, nil
+}
+
+// extremes returns the volumes with the least and the most free space.
+func extremes(free map[string]int64) (fullest string, emptiest string) {
+	cnames := []string{}
+	for cname := range free {
+		cnames = append(cnames, cname)
+	}
+	sort.Strings(cnames)
+	for _, cname := range cnames {
+		if fullest == "" || free[cname] < free[fullest] {
+			fullest = cname
+		}
+		if emptiest == "" || free[cname] > free[emptiest] {
+			emptiest = cname
+		}
+	}
+	return fullest, emptiest
+}
+
+// largestMovable returns the largest file, not bigger than the limit, which can be moved out of the source volume into
+// the target one; none if there is no such a file.
+func (handle *Handle) largestMovable(volCatalog *catalog.Catalog, source string, target string, limit int64, holders map[string]map[string]bool) string {
+	largest := ""
+	for _, path := range volCatalog.Paths() {
+		entry := volCatalog.Entries[path]
+		if entry.Size > limit || entry.Damaged || holders[path][target] {
+			continue
+		}
+		if largest != "" && entry.Size <= volCatalog.Entries[largest].Size {
+			continue
+		}
+		if _, deleted := handle.Jbov.Deleted[path]; deleted {
+			continue
+		}
+		_, in := handle.Jbov.Requirement(path, entry.Size, entry.Mtime)
+		required := false
+		for _, cname := range in {
+			required = required || cname == source
+		}
+		if !required {
+			largest = path
+		}
+	}
+	return largest
+}
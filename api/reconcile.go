@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"github.com/kuking/jbov/api/md"
+)
+
+type metadataCopy struct {
+	cname    string
+	raw      []byte
+	jbov     *md.JBOV
+	migrated bool
+	forged   bool
+}
+
+// SplitBrainError is returned when the metadata copies have been edited independently, i.e. the JBOV has been used in
+// different places with disjoint sets of volumes. Heads maps every forked edit to the volumes holding it.
+type SplitBrainError struct {
+	Heads map[string][]string
+}
+
+func (err *SplitBrainError) Error() string {
+	groups := []string{}
+	for _, cnames := range err.Heads {
+		groups = append(groups, "["+strings.Join(cnames, ", ")+"]")
+	}
+	sort.Strings(groups)
+	return fmt.Sprintf("JBOV metadata has been edited independently in volumes %s, it has to be merged (jbov metadata merge)", strings.Join(groups, " and "))
+}
+
+// reconcile reads the metadata copy held by every present volume and picks the newest one, ties are resolved in
+// favour of the in-memory metadata (read as openedRaw). It returns the winner and the cnames of the volumes holding a
+// different copy than the winner or one in an older schema version, sorted. Forked copies can not be reconciled and a
+// SplitBrainError is returned, neither copies failing signature verification, for which a SignatureError is returned.
+func (handle *Handle) reconcile(openedRaw []byte) (*md.JBOV, []string, error) {
+	copies := handle.readCopies()
+	for _, copy := range copies {
+		if copy.forged {
+			return nil, nil, &SignatureError{Cname: copy.cname, MountPoint: handle.Volumes[copy.cname].MountPoint}
+		}
+	}
+
+	if tips := forkedTips(copies); len(tips) > 1 {
+		err := SplitBrainError{Heads: make(map[string][]string)}
+		for _, copy := range copies {
+			for _, tip := range tips {
+				if copy.jbov != nil && tip.jbov.Descends(copy.jbov) {
+					err.Heads[tip.jbov.Head()] = append(err.Heads[tip.jbov.Head()], copy.cname)
+					break
+				}
+			}
+		}
+		return nil, nil, &err
+	}
+
+	winner := metadataCopy{raw: openedRaw, jbov: handle.Jbov}
+	for _, copy := range copies {
+		if copy.jbov != nil && copy.jbov.IsNewerThan(winner.jbov) {
+			winner = copy
+		}
+	}
+
+	divergent := []string{}
+	for _, copy := range copies {
+		if copy.jbov == nil || copy.migrated || !bytes.Equal(copy.raw, winner.raw) {
+			divergent = append(divergent, copy.cname)
+		}
+	}
+	sort.Strings(divergent)
+	return winner.jbov, divergent, nil
+}
+
+// readCopies reads the metadata held by every present volume, damaged or foreign copies are returned without metadata.
+// Copies in older schema versions are migrated, copies failing signature verification are flagged as forged.
+func (handle *Handle) readCopies() []metadataCopy {
+	copies := []metadataCopy{}
+	for _, cname := range handle.Present() {
+		raw, err := ioutil.ReadFile(filepath.Join(handle.Volumes[cname].MountPoint, md.JBOV_FNAME))
+		if err != nil {
+			copies = append(copies, metadataCopy{cname: cname})
+			continue
+		}
+		jbov, migrated, err := md.Load(raw)
+		if err != nil || jbov.Uniqid != handle.Jbov.Uniqid {
+			copies = append(copies, metadataCopy{cname: cname})
+			continue
+		}
+		if handle.key != nil && !md.VerifyRawSignature(raw, handle.key) {
+			copies = append(copies, metadataCopy{cname: cname, forged: true})
+			continue
+		}
+		copies = append(copies, metadataCopy{cname: cname, raw: raw, jbov: jbov, migrated: migrated})
+	}
+	return copies
+}
+
+// forkedTips returns the copies no other copy descends from, one per distinct head. A single tip means the copies
+// history is linear.
+func forkedTips(copies []metadataCopy) []metadataCopy {
+	tips := []metadataCopy{}
+	for _, copy := range copies {
+		if copy.jbov == nil {
+			continue
+		}
+		isTip := true
+		for _, other := range copies {
+			if other.jbov != nil && other.jbov.Head() != copy.jbov.Head() && other.jbov.Descends(copy.jbov) {
+				isTip = false
+			}
+		}
+		for _, tip := range tips {
+			if tip.jbov.Head() == copy.jbov.Head() {
+				isTip = false
+			}
+		}
+		if isTip {
+			tips = append(tips, copy)
+		}
+	}
+	return tips
+}
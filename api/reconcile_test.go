@@ -0,0 +1,81 @@
+package api
+
+import (
+	"testing"
+	"path/filepath"
+	"io/ioutil"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen_newestMetadataCopyWinsAndIsWrittenOntoLaggingVolumes(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	newer := jbov
+	newer.Rules = []md.Rule{{Pattern: "*.jpg", Ncopies: 2}}
+	newer.Touch()
+	givenMetadataCopy(&newer, jbov.Volumes["vol2"].LastMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, newer.Generation, handle.Jbov.Generation)
+	assert.Equal(t, newer.Rules, handle.Jbov.Rules)
+	assert.Equal(t, []string{"vol1"}, handle.Divergent)
+	assert.Equal(t, newer.Rules, readMetadataCopy(jbov.Volumes["vol1"].LastMountPoint).Rules)
+}
+
+func TestOpen_olderMetadataCopyIsRewritten(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	older := jbov
+	older.Generation = 0
+	older.Rules = []md.Rule{{Pattern: "*.jpg", Ncopies: 2}}
+	givenMetadataCopy(&older, jbov.Volumes["vol2"].LastMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, jbov.Generation, handle.Jbov.Generation)
+	assert.Equal(t, []string{"vol2"}, handle.Divergent)
+	assert.Equal(t, jbov.Generation, readMetadataCopy(jbov.Volumes["vol2"].LastMountPoint).Generation)
+	assert.Empty(t, readMetadataCopy(jbov.Volumes["vol2"].LastMountPoint).Rules)
+}
+
+func TestOpen_damagedMetadataCopyIsRewritten(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, md.JBOV_FNAME), []byte("{ garbage"), 0644)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, handle.Divergent)
+	assert.Equal(t, jbov.Uniqid, readMetadataCopy(jbov.Volumes["vol2"].LastMountPoint).Uniqid)
+}
+
+func TestOpen_identicalMetadataCopiesAreNotDivergent(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Empty(t, handle.Divergent)
+}
+
+// utility
+
+func givenMetadataCopy(jbov *md.JBOV, mountPoint string) {
+	jsonb, _ := jbov.Marshal()
+	ioutil.WriteFile(filepath.Join(mountPoint, md.JBOV_FNAME), jsonb, 0644)
+}
+
+func readMetadataCopy(mountPoint string) md.JBOV {
+	jsonb, _ := ioutil.ReadFile(filepath.Join(mountPoint, md.JBOV_FNAME))
+	jbov, _, err := md.Load(jsonb)
+	if err != nil {
+		return md.JBOV{}
+	}
+	return *jbov
+}
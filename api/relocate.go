@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/kuking/jbov/api/md"
+)
+
+// SearchDirs are scanned (up to SEARCH_DEPTH levels deep) looking for volumes that are not in their last known mount
+// point, on top of every mount point listed in MountsFile.
+var SearchDirs = []string{"/media", "/run/media", "/mnt", "/Volumes"}
+var MountsFile = "/proc/mounts"
+
+const SEARCH_DEPTH = 2
+
+var pseudoFilesystems = map[string]bool{
+	"proc": true, "sysfs": true, "devpts": true, "devtmpfs": true, "cgroup": true, "cgroup2": true, "securityfs": true,
+	"debugfs": true, "tracefs": true, "mqueue": true, "pstore": true, "bpf": true, "configfs": true, "fusectl": true,
+	"hugetlbfs": true, "autofs": true, "binfmt_misc": true, "nsfs": true,
+}
+
+// relocate looks for the volumes not found in their last known mount point and updates their LastMountPoint when
+// found somewhere else, the filesystem fingerprint of present volumes is updated as well. It returns true when the
+// metadata has been changed.
+func (handle *Handle) relocate() bool {
+	changed := false
+	var found map[string]string
+	for cname, status := range handle.Volumes {
+		vol := handle.Jbov.Volumes[cname]
+		if status.State != VOLUME_PRESENT {
+			if found == nil {
+				found = scanForVolumes(candidateRoots())
+			}
+			if mountPoint, ok := found[vol.Uniqid]; ok {
+				status.MountPoint = mountPoint
+				status.State = VOLUME_PRESENT
+			}
+		}
+		if status.State == VOLUME_PRESENT && vol.LastMountPoint != status.MountPoint {
+			vol.LastMountPoint = status.MountPoint
+			changed = true
+		}
+		if status.State == VOLUME_PRESENT {
+			if fs, err := filesystemOf(status.MountPoint); err == nil && vol.Filesystem != fs.Fingerprint() {
+				vol.Filesystem = fs.Fingerprint()
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func candidateRoots() []string {
+	roots := []string{}
+	for _, dir := range SearchDirs {
+		roots = append(roots, walkDirs(dir, SEARCH_DEPTH)...)
+	}
+	for _, mountPoint := range readMountPoints(MountsFile) {
+		roots = append(roots, walkDirs(mountPoint, 1)...)
+	}
+	return roots
+}
+
+func walkDirs(dir string, depth int) []string {
+	dirs := []string{dir}
+	if depth == 0 {
+		return dirs
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return dirs
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			dirs = append(dirs, walkDirs(filepath.Join(dir, info.Name()), depth-1)...)
+		}
+	}
+	return dirs
+}
+
+func readMountPoints(mountsFile string) []string {
+	mountPoints := []string{}
+	f, err := os.Open(mountsFile)
+	if err != nil {
+		return mountPoints
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] {
+			continue
+		}
+		// spaces and other special characters are octal escaped in the mounts file
+		mountPoint := strings.Replace(fields[1], "\\040", " ", -1)
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	return mountPoints
+}
+
+// scanForVolumes returns the volume uniqids found in the given directories, mapped to the directory holding it.
+func scanForVolumes(dirs []string) map[string]string {
+	found := make(map[string]string)
+	for _, dir := range dirs {
+		uniqid, err := ioutil.ReadFile(filepath.Join(dir, md.UNIQID_FNAME))
+		if err != nil {
+			continue
+		}
+		if _, ok := found[string(uniqid)]; !ok {
+			found[string(uniqid)] = dir
+		}
+	}
+	return found
+}
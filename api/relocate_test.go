@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+	"io/ioutil"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen_relocatesMovedVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	searchDir, _ := ioutil.TempDir(os.TempDir(), "")
+	defer os.RemoveAll(searchDir)
+	defer givenSearchDirs([]string{searchDir})()
+	newMountPoint := filepath.Join(searchDir, "disk", "jbov")
+	os.MkdirAll(filepath.Dir(newMountPoint), 0755)
+	os.Rename(jbov.Volumes["vol2"].LastMountPoint, newMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1", "vol2"}, handle.Present())
+	assert.Equal(t, newMountPoint, handle.Jbov.Volumes["vol2"].LastMountPoint)
+	for _, mountPoint := range []string{jbov.Volumes["vol1"].LastMountPoint, newMountPoint} {
+		jsonb, _ := ioutil.ReadFile(filepath.Join(mountPoint, md.JBOV_FNAME))
+		jbovInVol, _, _ := md.Load(jsonb)
+		assert.Equal(t, newMountPoint, jbovInVol.Volumes["vol2"].LastMountPoint)
+	}
+}
+
+func TestOpen_relocationLeavesUnfoundVolumesMissing(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	os.RemoveAll(jbov.Volumes["vol2"].LastMountPoint)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, handle.Missing())
+	assert.Equal(t, jbov.Volumes["vol2"].LastMountPoint, handle.Jbov.Volumes["vol2"].LastMountPoint)
+}
+
+func TestReadMountPoints_skipsPseudoFilesystems(t *testing.T) {
+	f, _ := ioutil.TempFile(os.TempDir(), "")
+	defer os.Remove(f.Name())
+	f.WriteString("proc /proc proc rw 0 0\n/dev/sdb1 /media/my\\040disk ext4 rw 0 0\n")
+	f.Close()
+
+	assert.Equal(t, []string{"/media/my disk"}, readMountPoints(f.Name()))
+}
+
+// utility
+
+func givenSearchDirs(dirs []string) func() {
+	searchDirs, mountsFile := SearchDirs, MountsFile
+	SearchDirs, MountsFile = dirs, "/nonexistent"
+	return func() { SearchDirs, MountsFile = searchDirs, mountsFile }
+}
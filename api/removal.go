@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/md"
+)
+
+// PlanVolumeRemoval plans taking a volume out of the JBOV: every file it holds is first copied to the other volumes
+// when, without it, there would be fewer copies than its rules require (at least one). The volume must be writable,
+// so its files can be preserved, and not named by any rule. The volume is not removed when any of its files can not
+// be preserved. A missing volume, i.e. a dead disk, can only be removed when forced; its files are lost, only the
+// metadata is rewritten.
+func (handle *Handle) PlanVolumeRemoval(cname string, force bool) (*Plan, error) {
+	if _, ok := handle.Jbov.Volumes[cname]; !ok {
+		return nil, errors.New(fmt.Sprintf("Volume \"%s\" is not part of JBOV \"%s\"", cname, handle.Jbov.Cname))
+	}
+	for _, rule := range handle.Jbov.Rules {
+		if rule.AtLeastACopyIn == cname {
+			return nil, errors.New(fmt.Sprintf("Volume \"%s\" is required by the rule \"%s\", delete the rule first", cname, rule.Pattern))
+		}
+	}
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := catalogs[cname]; !ok {
+		if !force || handle.Volumes[cname].State != VOLUME_MISSING {
+			return nil, errors.New(fmt.Sprintf("Volume \"%s\" is not available, its files can not be preserved", cname))
+		}
+		plan := handle.newPlan("volume remove", catalogs)
+		plan.finding(Finding{FINDING_MISSING_VOLUME, SEVERITY_WARNING, cname, "", "missing, the files it holds are not preserved"})
+		plan.add(Op{Kind: OP_REMOVE_VOLUME, Target: cname, Reason: "missing volume removed"})
+		handle.account(plan)
+		return plan, nil
+	}
+	plan := handle.newPlan("volume remove", catalogs)
+	used := make(map[string]int64)
+	for other, volCatalog := range catalogs {
+		for _, entry := range volCatalog.Entries {
+			used[other] += entry.Size
+		}
+	}
+
+	for _, path := range catalogs[cname].Paths() {
+		if _, deleted := handle.Jbov.Deleted[path]; deleted {
+			continue
+		}
+		held := map[string]bool{cname: true}
+		cnames := []string{cname}
+		for _, other := range handle.Writable() {
+			if _, ok := catalogs[other].Entries[path]; ok && other != cname {
+				held[other] = true
+				cnames = append(cnames, other)
+			}
+		}
+		source, ok := syncSource(path, cnames, catalogs)
+		if !ok {
+			plan.finding(Finding{FINDING_REPLICAS_DIFFER, SEVERITY_ERROR, "", path, "replicas differ or are damaged, repair it first"})
+			continue
+		}
+		entry := catalogs[source].Entries[path]
+		ncopies, copies, _ := handle.Jbov.Replication(path, entry.Size, entry.Mtime, cnames[1:])
+		if ncopies < 1 {
+			ncopies = 1
+		}
+		for copies < ncopies {
+			target := leastUsed(used, held, handle)
+			if target == "" {
+				plan.finding(Finding{FINDING_UNDER_REPLICATED, SEVERITY_ERROR, "", path, fmt.Sprintf("%d copies required, but only %d volumes can hold them", ncopies, copies)})
+				break
+			}
+			plan.add(Op{OP_COPY, path, source, target, entry.Size, entry.Hash, "volume removed"})
+			held[target] = true
+			used[target] += entry.Size
+			copies++
+		}
+	}
+	if !plan.HasErrors() {
+		plan.add(Op{Kind: OP_REMOVE_VOLUME, Target: cname, Reason: "volume removed"})
+	}
+	handle.account(plan)
+	return plan, nil
+}
+
+// removeVolume takes a volume out of the JBOV metadata, and the JBOV files out of the volume; the files it holds are
+// left in place.
+func (handle *Handle) removeVolume(cname string) error {
+	status, ok := handle.Volumes[cname]
+	if !ok {
+		return errors.New(fmt.Sprintf("volume \"%s\" is not part of the JBOV", cname))
+	}
+	delete(handle.Jbov.Volumes, cname)
+	for _, deleted := range handle.Jbov.Deleted {
+		pending := []string{}
+		for _, other := range deleted.Pending {
+			if other != cname {
+				pending = append(pending, other)
+			}
+		}
+		deleted.Pending = pending
+	}
+	delete(handle.Volumes, cname)
+	if err := handle.Save(); err != nil {
+		return err
+	}
+	if status.State != VOLUME_PRESENT {
+		return nil
+	}
+	for _, fname := range []string{md.JBOV_FNAME, md.UNIQID_FNAME, catalog.CATALOG_FNAME, JOURNAL_FNAME} {
+		if err := os.Remove(filepath.Join(status.MountPoint, fname)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(status.MountPoint, md.HISTORY_DIRNAME))
+}
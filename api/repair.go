@@ -0,0 +1,232 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/hashing"
+)
+
+// REPAIRS_FNAME logs, in every volume, the repairs done to it; a JSON RepairAction per line.
+const REPAIRS_FNAME = ".jbov.repairs"
+
+// RepairAction is the replacement of a bad replica by a good copy held in another volume.
+type RepairAction struct {
+	Ts          int64 `json:"ts"`
+	Host        string `json:"host"`
+	Path        string `json:"path"`
+	Cname       string `json:"volume"`
+	Source      string `json:"source"`
+	Hash        string `json:"hash"`
+	Reason      string `json:"reason"`
+	Trashed     string `json:"trashed,omitempty"`
+}
+
+// replica is a copy of a file as found when repairing: its catalogued and current hash.
+type replica struct {
+	cname  string
+	stored string
+	sum    string
+	err    error
+}
+
+func (r *replica) isRotten() bool {
+	return r.err == nil && r.stored != "" && r.sum != r.stored
+}
+
+// PlanRepair plans healing the replicas found bad by the last checks: damaged ones (bit rot) and the ones whose
+// content differs from the majority of the replicas. Every replica of those files is hashed again; a bad one is to be
+// replaced by a copy of a replica matching both its catalogued hash and the majority. Files without such a trustworthy
+// copy are refused.
+func (handle *Handle) PlanRepair() (*Plan, error) {
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, err
+	}
+	plan := handle.newPlan("repair", catalogs)
+	for _, path := range suspicious(catalogs) {
+		replicas := handle.hashReplicas(path, catalogs)
+		reference, source := trustworthy(replicas)
+		if source == "" {
+			plan.finding(Finding{FINDING_REPLICAS_DIFFER, SEVERITY_ERROR, "", path, "there is no trustworthy copy to repair it from"})
+			continue
+		}
+		for _, r := range replicas {
+			if r.err == nil && !r.isRotten() && r.sum == reference {
+				continue
+			}
+			reason := "differs from the majority"
+			if r.isRotten() {
+				reason = "bit rot"
+			} else if r.err != nil {
+				reason = "unreadable"
+			}
+			plan.add(Op{OP_REPAIR, path, source, r.cname, catalogs[source].Entries[path].Size, reference, reason})
+		}
+	}
+	handle.account(plan)
+	return plan, nil
+}
+
+// suspicious returns the paths with a damaged replica, or whose replicas were catalogued with different hashes.
+func suspicious(catalogs map[string]*catalog.Catalog) []string {
+	hashes := make(map[string]map[string]bool)
+	damaged := make(map[string]bool)
+	for _, volCatalog := range catalogs {
+		for path, entry := range volCatalog.Entries {
+			if hashes[path] == nil {
+				hashes[path] = make(map[string]bool)
+			}
+			if entry.Hash != "" {
+				hashes[path][entry.Hash] = true
+			}
+			if entry.Damaged {
+				damaged[path] = true
+			}
+		}
+	}
+	paths := []string{}
+	for path, sums := range hashes {
+		if damaged[path] || len(sums) > 1 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// hashReplicas hashes every replica of a file again, sorted by volume cname.
+func (handle *Handle) hashReplicas(path string, catalogs map[string]*catalog.Catalog) []*replica {
+	replicas := []*replica{}
+	for cname, volCatalog := range catalogs {
+		entry, ok := volCatalog.Entries[path]
+		if !ok {
+			continue
+		}
+		r := replica{cname: cname, stored: entry.Hash}
+		r.sum, r.err = hashing.File(handle.Jbov.Hashing(), filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path)))
+		replicas = append(replicas, &r)
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].cname < replicas[j].cname })
+	return replicas
+}
+
+// trustworthy returns the content held by the strict majority of the readable, not rotten, replicas and a volume
+// holding a copy of it which matches its catalogued hash; no source when there is no such a copy.
+func trustworthy(replicas []*replica) (reference string, source string) {
+	votes := make(map[string]int)
+	voters := 0
+	for _, r := range replicas {
+		if r.err == nil && !r.isRotten() {
+			votes[r.sum]++
+			voters++
+		}
+	}
+	for sum, count := range votes {
+		if count*2 > voters {
+			reference = sum
+		}
+	}
+	if reference == "" {
+		return "", ""
+	}
+	for _, r := range replicas {
+		if r.err == nil && r.stored == reference && r.sum == reference {
+			return reference, r.cname
+		}
+	}
+	return "", ""
+}
+
+// repairReplica replaces a bad replica with a verified copy of the source one, the bad one is moved to the trash rather
+// than deleted and the action logged in the repaired volume; which quarantines it, kept until the trash is emptied.
+func (handle *Handle) repairReplica(op Op, trashId string, catalogs map[string]*catalog.Catalog) error {
+	src := filepath.Join(handle.Volumes[op.Source].MountPoint, filepath.FromSlash(op.Path))
+	mountPoint := handle.Volumes[op.Target].MountPoint
+	dst := filepath.Join(mountPoint, filepath.FromSlash(op.Path))
+
+	tmp, err := stageCopy(src, dst)
+	if err != nil {
+		return err
+	}
+	if sum, err := hashing.File(handle.Jbov.Hashing(), tmp); err != nil || sum != op.Hash {
+		os.Remove(tmp)
+		return errors.New(fmt.Sprintf("Copy of %s from volume \"%s\" does not verify, it has not been repaired", op.Path, op.Source))
+	}
+	action := RepairAction{Path: op.Path, Cname: op.Target, Source: op.Source, Hash: op.Hash, Reason: op.Reason}
+	// already trashed when resuming an interrupted repair
+	if _, err := os.Lstat(dst); err == nil {
+		if action.Trashed, err = handle.trash(op.Target, trashId, op.Path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	entry := catalog.EntryOf(info)
+	entry.Hash, entry.Verified = op.Hash, time.Now().UnixNano()
+	catalogs[op.Target].Entries[op.Path] = entry
+
+	action.Ts = time.Now().Unix()
+	action.Host, _ = os.Hostname()
+	return appendRepairLog(mountPoint, &action)
+}
+
+// quarantinedIn returns the bad replicas moved into the trash of the volume at mountPoint by a repair, as logged; by
+// their trash operation id and path, slash separated.
+func quarantinedIn(mountPoint string) (map[string]bool, error) {
+	quarantined := make(map[string]bool)
+	f, err := os.Open(filepath.Join(mountPoint, REPAIRS_FNAME))
+	if os.IsNotExist(err) {
+		return quarantined, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var action RepairAction
+		if json.Unmarshal(scanner.Bytes(), &action) != nil || action.Trashed == "" {
+			continue
+		}
+		// the volume might have been mounted elsewhere when repaired
+		trashed := filepath.ToSlash(action.Trashed)
+		if i := strings.LastIndex(trashed, "/"+TRASH_DIRNAME+"/"); i >= 0 {
+			quarantined[trashed[i+len(TRASH_DIRNAME)+2:]] = true
+		}
+	}
+	return quarantined, scanner.Err()
+}
+
+func appendRepairLog(mountPoint string, action *RepairAction) error {
+	jsonb, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(mountPoint, REPAIRS_FNAME), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(jsonb, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
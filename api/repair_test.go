@@ -0,0 +1,135 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepair_replacesARottenReplica(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+	rotten := filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "file.txt")
+	givenBitRot(t, rotten, "jello")
+	handle.Scrub(ScrubOptions{})
+
+	plan, err := handle.PlanRepair()
+	assert.NoError(t, err)
+	assert.Len(t, plan.Ops, 1)
+	op := plan.Ops[0]
+	assert.Equal(t, OP_REPAIR, op.Kind)
+	assert.Equal(t, "vol2", op.Target)
+	assert.Equal(t, "vol1", op.Source)
+	assert.Equal(t, "bit rot", op.Reason)
+
+	done, err := handle.Apply(plan)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, done)
+	content, _ := ioutil.ReadFile(rotten)
+	assert.Equal(t, "hello", string(content))
+	trashed, _ := filepath.Glob(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, TRASH_DIRNAME, "*", "file.txt"))
+	assert.Len(t, trashed, 1)
+	content, _ = ioutil.ReadFile(trashed[0])
+	assert.Equal(t, "jello", string(content))
+	repairs, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, REPAIRS_FNAME))
+	assert.Equal(t, 1, strings.Count(string(repairs), "\n"))
+
+	scrub, _ := handle.Scrub(ScrubOptions{})
+	assert.Empty(t, fileFindings(scrub))
+}
+
+func TestRepair_replacesTheReplicaDifferingFromTheMajority(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	givenFileIn(&jbov, "file.txt", "bye", "vol3")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+
+	plan, _ := handle.PlanRepair()
+	_, err := handle.Apply(plan)
+
+	assert.NoError(t, err)
+	assert.Len(t, plan.Ops, 1)
+	assert.Equal(t, "vol3", plan.Ops[0].Target)
+	assert.Equal(t, "differs from the majority", plan.Ops[0].Reason)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol3"].LastMountPoint, "file.txt"))
+	assert.Equal(t, "hello", string(content))
+}
+
+func TestRepair_refusesWithoutATrustworthyCopy(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1")
+	givenFileIn(&jbov, "file.txt", "bye", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+
+	plan, err := handle.PlanRepair()
+
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+	assert.Equal(t, []Finding{{FINDING_REPLICAS_DIFFER, SEVERITY_ERROR, "", "file.txt", "there is no trustworthy copy to repair it from"}}, plan.Findings)
+}
+
+func TestPlanRepair_changesNothing(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	givenFileIn(&jbov, "file.txt", "bye", "vol3")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+
+	plan, err := handle.PlanRepair()
+
+	assert.NoError(t, err)
+	assert.Len(t, plan.Ops, 1)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol3"].LastMountPoint, "file.txt"))
+	assert.Equal(t, "bye", string(content))
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol3"].LastMountPoint, TRASH_DIRNAME))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRepair_quarantinedReplicasAreKeptByTheTrashRetention(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Trash = &md.TrashRetention{MaxAgeDays: 1, MaxBytes: 1}
+	handle.Scrub(ScrubOptions{})
+	givenBitRot(t, filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "file.txt"), "jello")
+	handle.Scrub(ScrubOptions{})
+	plan, _ := handle.PlanRepair()
+	handle.Apply(plan)
+
+	files, _, err := handle.purgeTrashRetention()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, files)
+	entries, _ := handle.Trash()
+	assert.Len(t, entries, 1)
+	assert.True(t, entries[0].Quarantined)
+	files, _, err = handle.EmptyTrash(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, files)
+}
+
+// utility
+
+func givenThreeVolumesJBOV(t *testing.T) md.JBOV {
+	jbov := givenValidJBOV()
+	jbov.Volumes["vol3"] = &md.Volume{Uniqid: md.GenerateVolumeUniqId(), LastMountPoint: "/mnt/vol3"}
+	givenMountPointsExist(&jbov)
+	if _, err := Create(&jbov); err != nil {
+		t.Fatal(err)
+	}
+	return jbov
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kuking/jbov/api/md"
+)
+
+// RuleUsage is what a rule currently matches: the number of files, and their size; every file counted once, however
+// many replicas it has.
+type RuleUsage struct {
+	Files int
+	Bytes int64
+}
+
+// AddRule adds a redundancy rule, it is validated on its own and along with the rest of the metadata, which is then
+// written onto every present volume.
+func (handle *Handle) AddRule(rule md.Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	lock, err := handle.Lock("rule add")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	handle.Jbov.Rules = append(handle.Jbov.Rules, rule)
+	if ok, err := handle.Jbov.IsValid(); !ok {
+		handle.Jbov.Rules = handle.Jbov.Rules[:len(handle.Jbov.Rules)-1]
+		return err
+	}
+	return handle.Save()
+}
+
+// DeleteRule removes the redundancy rule at the given index, as listed, and returns it. The index is checked against
+// the rules as they are once locked, the metadata read before might be outdated.
+func (handle *Handle) DeleteRule(index int) (*md.Rule, error) {
+	lock, err := handle.Lock("rule del")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	if index < 0 || index >= len(handle.Jbov.Rules) {
+		return nil, errors.New(fmt.Sprintf("There is no rule %d, JBOV \"%s\" has %d rule(s)", index, handle.Jbov.Cname, len(handle.Jbov.Rules)))
+	}
+	rule := handle.Jbov.Rules[index]
+	handle.Jbov.Rules = append(handle.Jbov.Rules[:index], handle.Jbov.Rules[index+1:]...)
+	return &rule, handle.Save()
+}
+
+// Deprecate marks a volume as deprecated, or not: no copies are added to deprecated volumes and the ones they hold do
+// not count as redundant copies.
+func (handle *Handle) Deprecate(cname string, deprecated bool) error {
+	lock, err := handle.Lock("deprecate")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	vol, ok := handle.Jbov.Volumes[cname]
+	if !ok {
+		return errors.New(fmt.Sprintf("Volume \"%s\" is not part of JBOV \"%s\"", cname, handle.Jbov.Cname))
+	}
+	vol.Deprecated = deprecated
+	return handle.Save()
+}
+
+// RuleUsage returns what every rule currently matches in the writable volumes, by rule index.
+func (handle *Handle) RuleUsage() ([]RuleUsage, error) {
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]RuleUsage, len(handle.Jbov.Rules))
+	counted := make(map[string]bool)
+	for _, volCatalog := range catalogs {
+		for path, entry := range volCatalog.Entries {
+			if counted[path] {
+				continue
+			}
+			counted[path] = true
+			for i := range handle.Jbov.Rules {
+				if handle.Jbov.Rules[i].Matches(path, entry.Size, entry.Mtime) {
+					usage[i].Files++
+					usage[i].Bytes += entry.Size
+				}
+			}
+		}
+	}
+	return usage, nil
+}
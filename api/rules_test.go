@@ -0,0 +1,121 @@
+package api
+
+import (
+	"testing"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRule_writesItOntoEveryVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	err := handle.AddRule(md.Rule{Pattern: "*.mk4", Ncopies: 2})
+
+	assert.NoError(t, err)
+	for _, cname := range []string{"vol1", "vol2"} {
+		reopened, _ := Open(jbov.Volumes[cname].LastMountPoint)
+		assert.Equal(t, []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}, reopened.Jbov.Rules)
+	}
+	assert.False(t, handle.IsLocked())
+}
+
+func TestAddRule_refusesInvalidRules(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	err := handle.AddRule(md.Rule{Pattern: "*.mk4", AtLeastACopyIn: "nonexistent"})
+
+	assert.EqualError(t, err, "JBOV rule at-least-a-copy-in refers to an invalid volume: nonexistent")
+	assert.Empty(t, handle.Jbov.Rules)
+
+	err = handle.AddRule(md.Rule{Pattern: "re:(", Ncopies: 2})
+
+	assert.EqualError(t, err, "JBOV rule pattern is not valid: re:(, error parsing regexp: missing closing ): `(`")
+	assert.Empty(t, handle.Jbov.Rules)
+}
+
+func TestOpen_warnsAboutRulesWithAPatternNotWellFormed(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}, {Pattern: "size>big", Ncopies: 2}}
+	writeMetadata(&jbov, []string{jbov.Volumes["vol1"].LastMountPoint, jbov.Volumes["vol2"].LastMountPoint}, nil)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Contains(t, handle.Warnings, Problem{PROBLEM_INVALID_RULE, "",
+		"rule 1 matches nothing, JBOV rule pattern is not valid: size>big, expected a size like size>1GiB"})
+	assert.Equal(t, []string{"vol1", "vol2"}, handle.Writable())
+}
+
+func TestSyncAndCheck_reportRulesWithAPatternNotWellFormed(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	jbov.Rules = []md.Rule{{Pattern: "size>big", Ncopies: 2}}
+	writeMetadata(&jbov, []string{jbov.Volumes["vol1"].LastMountPoint, jbov.Volumes["vol2"].LastMountPoint}, nil)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	detail := "rule 0 matches nothing, JBOV rule pattern is not valid: size>big, expected a size like size>1GiB"
+
+	plan, err := handle.PlanSync()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Finding{{FINDING_INVALID_RULE, SEVERITY_WARNING, "", "", detail}}, plan.Findings)
+
+	report, err := handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Contains(t, report.Findings, Finding{FINDING_INVALID_RULE, SEVERITY_ERROR, "", "", detail})
+}
+
+func TestDeleteRule(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.AddRule(md.Rule{Pattern: "*.mk4", Ncopies: 2})
+	handle.AddRule(md.Rule{Pattern: "*.txt", Ncopies: 3})
+
+	rule, err := handle.DeleteRule(0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "*.mk4", rule.Pattern)
+	reopened, _ := Open(jbov.Volumes["vol2"].LastMountPoint)
+	assert.Equal(t, []md.Rule{{Pattern: "*.txt", Ncopies: 3}}, reopened.Jbov.Rules)
+	_, err = handle.DeleteRule(1)
+	assert.EqualError(t, err, "There is no rule 1, JBOV \""+jbov.Cname+"\" has 1 rule(s)")
+}
+
+func TestDeleteRule_checksTheRulesAsTheyAreOnceLocked(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	stale, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.AddRule(md.Rule{Pattern: "*.mk4", Ncopies: 2})
+	handle.AddRule(md.Rule{Pattern: "*.txt", Ncopies: 3})
+
+	rule, err := stale.DeleteRule(1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "*.txt", rule.Pattern)
+	reopened, _ := Open(jbov.Volumes["vol2"].LastMountPoint)
+	assert.Equal(t, []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}, reopened.Jbov.Rules)
+	_, err = stale.DeleteRule(1)
+	assert.EqualError(t, err, "There is no rule 1, JBOV \""+jbov.Cname+"\" has 1 rule(s)")
+}
+
+func TestRuleUsage_countsEveryFileOnce(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "a.mk4", "aaa", "vol1", "vol2")
+	givenFileIn(&jbov, "b.mk4", "bb", "vol2")
+	givenFileIn(&jbov, "c.txt", "c", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}, {Pattern: "*.jpg", Ncopies: 2}}
+
+	usage, err := handle.RuleUsage()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []RuleUsage{{2, 5}, {0, 0}}, usage)
+}
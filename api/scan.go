@@ -0,0 +1,52 @@
+package api
+
+import (
+	"github.com/kuking/jbov/api/catalog"
+)
+
+// Catalog returns the catalog last saved in the given volume, empty if it has never been scanned.
+func (handle *Handle) Catalog(cname string) (*catalog.Catalog, error) {
+	return catalog.Load(handle.Volumes[cname].MountPoint, handle.Jbov.Volumes[cname].Uniqid)
+}
+
+// Scan brings the catalog of every writable volume up to date and saves it, unless somebody else holds the lock; only the
+// files changed since the previous scan are examined. It returns the catalogs and the scan stats by volume cname.
+func (handle *Handle) Scan() (map[string]*catalog.Catalog, map[string]*catalog.Stats, error) {
+	catalogs := make(map[string]*catalog.Catalog)
+	stats := make(map[string]*catalog.Stats)
+	for _, cname := range handle.Writable() {
+		previous, err := handle.Catalog(cname)
+		if err != nil {
+			return nil, nil, err
+		}
+		mountPoint := handle.Volumes[cname].MountPoint
+		current, stat, err := catalog.Scan(mountPoint, previous)
+		if err != nil {
+			return nil, nil, err
+		}
+		catalogs[cname], stats[cname] = current, stat
+	}
+	if err := handle.saveCatalogs(catalogs); err != nil {
+		return nil, nil, err
+	}
+	return catalogs, stats, nil
+}
+
+// saveCatalogs saves the catalogs given by volume cname holding the lock, so they are not written while a locked
+// operation is updating them. Catalogs are a cache: when somebody else holds the lock they are not saved, and they will
+// be brought up to date next time.
+func (handle *Handle) saveCatalogs(catalogs map[string]*catalog.Catalog) error {
+	if handle.lock == nil {
+		lock, err := handle.Lock("catalog save")
+		if err != nil {
+			return nil
+		}
+		defer lock.Release()
+	}
+	for cname, volCatalog := range catalogs {
+		if err := volCatalog.Save(handle.Volumes[cname].MountPoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan_savesTheCatalogOfEveryVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "file.txt"), []byte("content"), 0644)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	_, stats, err := handle.Scan()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats["vol1"].Added)
+	assert.Equal(t, 0, stats["vol2"].Files)
+	saved, err := handle.Catalog("vol1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file.txt"}, saved.Paths())
+
+	_, stats, err = handle.Scan()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats["vol1"].Unchanged)
+}
+
+func TestScan_doesNotSaveTheCatalogsWhenLockedByOthers(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "file.txt"), []byte("content"), 0644)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenLockFile(handle, "vol2", LockInfo{Host: "other-host", Pid: 1, Expires: time.Now().Add(time.Hour).Unix()})
+
+	catalogs, _, err := handle.Scan()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file.txt"}, catalogs["vol1"].Paths())
+	saved, _ := handle.Catalog("vol1")
+	assert.Empty(t, saved.Paths())
+	assert.NotContains(t, handle.Locks(), "vol1")
+}
+
+func TestScan_savesTheCatalogsWhenTheLockIsAlreadyHeld(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	ioutil.WriteFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "file.txt"), []byte("content"), 0644)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	lock, _ := handle.Lock("test")
+
+	_, _, err := handle.Scan()
+
+	assert.NoError(t, err)
+	saved, _ := handle.Catalog("vol1")
+	assert.Equal(t, []string{"file.txt"}, saved.Paths())
+	assert.Contains(t, handle.Locks(), "vol1")
+	lock.Release()
+	assert.Empty(t, handle.Locks())
+}
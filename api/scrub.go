@@ -0,0 +1,289 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/hashing"
+)
+
+type Severity int
+
+const (
+	SEVERITY_INFO    Severity = iota
+	SEVERITY_WARNING
+	SEVERITY_ERROR
+)
+
+func (severity Severity) String() string {
+	switch severity {
+	case SEVERITY_INFO:
+		return "Info"
+	case SEVERITY_WARNING:
+		return "Warning"
+	}
+	return "Error"
+}
+
+type FindingKind int
+
+const (
+	FINDING_MISSING_VOLUME   FindingKind = iota
+	FINDING_METADATA
+	FINDING_UNREADABLE
+	FINDING_BIT_ROT
+	FINDING_REPLICAS_DIFFER
+	FINDING_UNDER_REPLICATED
+	FINDING_OVERDUE
+	FINDING_INVALID_RULE
+)
+
+// Finding is something found by a scrub, about a volume, a file (by its jbov path) or a file replica in a volume.
+type Finding struct {
+	Kind     FindingKind `json:"kind"`
+	Severity Severity `json:"severity"`
+	Cname    string `json:"volume,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+func (finding Finding) String() string {
+	switch {
+	case finding.Path != "" && finding.Cname != "":
+		return fmt.Sprintf("%s in volume \"%s\": %s", finding.Path, finding.Cname, finding.Detail)
+	case finding.Path != "":
+		return fmt.Sprintf("%s: %s", finding.Path, finding.Detail)
+	case finding.Cname == "":
+		return finding.Detail
+	}
+	return fmt.Sprintf("volume \"%s\": %s", finding.Cname, finding.Detail)
+}
+
+// ScrubOptions limit how much a scrub verifies, the zero value verifies every replica pending in the current pass.
+// Sample is the fraction (0 to 1) of every volume replicas to verify and MaxDuration the time to stop verifying at;
+// replicas not verified for longer than MaxAge are always verified, regardless of the sample.
+type ScrubOptions struct {
+	Sample      float64
+	MaxDuration time.Duration
+	MaxAge      time.Duration
+}
+
+// SCRUB_SAVE_EVERY is how often a scrub saves its progress.
+const SCRUB_SAVE_EVERY = 30 * time.Second
+
+// ScrubReport is the outcome of a scrub, findings are sorted by path (metadata findings first). Pending is the number
+// of replicas still to be verified in the current pass.
+type ScrubReport struct {
+	Findings []Finding
+	Files    int
+	Replicas int
+	Bytes    int64
+	Verified int
+	Hashed   int
+	Pending  int
+}
+
+// Worst returns the highest severity found, SEVERITY_INFO when there is nothing to report.
+func (report *ScrubReport) Worst() Severity {
+	worst := SEVERITY_INFO
+	for _, finding := range report.Findings {
+		if finding.Severity > worst {
+			worst = finding.Severity
+		}
+	}
+	return worst
+}
+
+// Count returns the number of findings of the given severity.
+func (report *ScrubReport) Count(severity Severity) int {
+	count := 0
+	for _, finding := range report.Findings {
+		if finding.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// Scrub verifies the whole JBOV: metadata consistency across volumes, the content of every file replica against its
+// catalogued hash (bit rot), that every replica of a file holds the same content and that files are as replicated as
+// the rules require. The catalogs of the writable volumes are brought up to date along the way.
+//
+// Replicas are verified in passes, least recently verified first, and the progress is saved in the catalogs as it
+// goes; a scrub limited by the options, or interrupted, carries on with the replicas pending in the current pass the
+// next time.
+func (handle *Handle) Scrub(options ScrubOptions) (*ScrubReport, error) {
+	report := ScrubReport{Findings: []Finding{}}
+	for _, cname := range handle.Missing() {
+		report.add(Finding{FINDING_MISSING_VOLUME, SEVERITY_WARNING, cname, "", fmt.Sprintf("not found in %s", handle.Volumes[cname].MountPoint)})
+	}
+	for _, cname := range handle.Divergent {
+		report.add(Finding{FINDING_METADATA, SEVERITY_INFO, cname, "", "metadata copy was outdated, it has been rewritten"})
+	}
+	for _, warning := range handle.Warnings {
+		if warning.Kind == PROBLEM_INVALID_RULE {
+			report.add(Finding{FINDING_INVALID_RULE, SEVERITY_ERROR, "", "", warning.Detail})
+			continue
+		}
+		report.add(Finding{FINDING_METADATA, SEVERITY_WARNING, warning.Cname, "", warning.Detail})
+	}
+	for _, problem := range handle.Problems {
+		report.add(Finding{FINDING_METADATA, SEVERITY_ERROR, problem.Cname, "", problem.Detail + ", it is not checked"})
+	}
+
+	deadline := time.Time{}
+	if options.MaxDuration > 0 {
+		deadline = time.Now().Add(options.MaxDuration)
+	}
+	catalogs := make(map[string]*catalog.Catalog)
+	for _, cname := range handle.Writable() {
+		volCatalog, err := handle.scrubVolume(cname, options, deadline, &report)
+		if err != nil {
+			return nil, err
+		}
+		catalogs[cname] = volCatalog
+	}
+
+	report.checkReplicas(handle, catalogs)
+	sort.SliceStable(report.Findings, func(i, j int) bool { return report.Findings[i].Path < report.Findings[j].Path })
+	return &report, nil
+}
+
+// scrubVolume scans a volume and verifies its replicas pending in the current pass, as allowed by the options.
+func (handle *Handle) scrubVolume(cname string, options ScrubOptions, deadline time.Time, report *ScrubReport) (*catalog.Catalog, error) {
+	mountPoint := handle.Volumes[cname].MountPoint
+	previous, err := handle.Catalog(cname)
+	if err != nil {
+		return nil, err
+	}
+	current, _, err := catalog.Scan(mountPoint, previous)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if current.Pass == 0 {
+		current.Pass = now.UnixNano()
+	}
+	pending := current.Unverified(current.Pass)
+
+	budget := len(pending)
+	if options.Sample > 0 && options.Sample < 1 {
+		budget = int(math.Ceil(options.Sample * float64(len(current.Entries))))
+	}
+	overdue := int64(0)
+	if options.MaxAge > 0 {
+		overdue = now.Add(-options.MaxAge).UnixNano()
+	}
+
+	algorithm := handle.Jbov.Hashing()
+	saved := now
+	verified := 0
+	for _, path := range pending {
+		entry := current.Entries[path]
+		mandatory := entry.Verified < overdue
+		if (verified >= budget && !mandatory) || (!deadline.IsZero() && time.Now().After(deadline)) {
+			break
+		}
+		hashed := entry.Hash != ""
+		ok, err := current.Verify(mountPoint, path, algorithm)
+		verified++
+		if err != nil {
+			entry.Verified = time.Now().UnixNano()
+			report.add(Finding{FINDING_UNREADABLE, SEVERITY_ERROR, cname, path, err.Error()})
+			continue
+		}
+		if !ok {
+			report.add(Finding{FINDING_BIT_ROT, SEVERITY_ERROR, cname, path, "content does not match its hash, but the file has not been modified (bit rot)"})
+		}
+		if hashed {
+			report.Verified++
+		} else {
+			report.Hashed++
+		}
+		if time.Since(saved) > SCRUB_SAVE_EVERY {
+			if err := handle.saveCatalogs(map[string]*catalog.Catalog{cname: current}); err != nil {
+				return nil, err
+			}
+			saved = time.Now()
+		}
+	}
+
+	left := current.Unverified(current.Pass)
+	report.Pending += len(left)
+	if len(left) == 0 {
+		current.Pass = 0
+	}
+	for _, path := range left {
+		if entry := current.Entries[path]; entry.Verified < overdue {
+			report.add(Finding{FINDING_OVERDUE, SEVERITY_WARNING, cname, path, fmt.Sprintf("not verified for longer than %s", options.MaxAge)})
+		}
+	}
+	return current, handle.saveCatalogs(map[string]*catalog.Catalog{cname: current})
+}
+
+func (report *ScrubReport) add(finding Finding) {
+	report.Findings = append(report.Findings, finding)
+}
+
+// checkReplicas compares the replicas of every file and counts them against the rules. Deprecated volumes do not
+// count as copies; while there are missing volumes under-replication is only a warning, they might hold the copies.
+func (report *ScrubReport) checkReplicas(handle *Handle, catalogs map[string]*catalog.Catalog) {
+	holders := make(map[string][]string)
+	for cname, volCatalog := range catalogs {
+		for path, entry := range volCatalog.Entries {
+			holders[path] = append(holders[path], cname)
+			report.Replicas++
+			report.Bytes += entry.Size
+		}
+	}
+	paths := []string{}
+	for path := range holders {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	report.Files = len(paths)
+
+	severity := SEVERITY_ERROR
+	if len(handle.Missing()) > 0 {
+		severity = SEVERITY_WARNING
+	}
+	algorithm := handle.Jbov.Hashing()
+	for _, path := range paths {
+		cnames := holders[path]
+		sort.Strings(cnames)
+
+		sums := make(map[string][]string)
+		for _, cname := range cnames {
+			if sum := catalogs[cname].Entries[path].Hash; sum != "" && hashing.AlgorithmOf(sum) == algorithm {
+				sums[sum] = append(sums[sum], cname)
+			}
+		}
+		if len(sums) > 1 {
+			report.add(Finding{FINDING_REPLICAS_DIFFER, SEVERITY_ERROR, "", path, fmt.Sprintf("replicas hold different content: %v", describeSums(sums))})
+		}
+
+		if _, deleted := handle.Jbov.Deleted[path]; deleted {
+			continue
+		}
+		entry := catalogs[cnames[0]].Entries[path]
+		ncopies, copies, missingIn := handle.Jbov.Replication(path, entry.Size, entry.Mtime, cnames)
+		if copies < ncopies {
+			report.add(Finding{FINDING_UNDER_REPLICATED, severity, "", path, fmt.Sprintf("%d copies found, %d required", copies, ncopies)})
+		}
+		for _, cname := range missingIn {
+			report.add(Finding{FINDING_UNDER_REPLICATED, severity, cname, path, "a copy is required in this volume"})
+		}
+	}
+}
+
+// describeSums lists the volumes holding every distinct content, sorted by hash.
+func describeSums(sums map[string][]string) []string {
+	described := []string{}
+	for sum, cnames := range sums {
+		described = append(described, fmt.Sprintf("%s in %v", sum, cnames))
+	}
+	sort.Strings(described)
+	return described
+}
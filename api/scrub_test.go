@@ -0,0 +1,210 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrub_healthyJBOV(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	report, err := handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Empty(t, fileFindings(report))
+	assert.Equal(t, 0, report.Count(SEVERITY_ERROR))
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, 2, report.Replicas)
+	assert.Equal(t, 2, report.Hashed)
+
+	report, err = handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Verified)
+}
+
+func TestScrub_detectsBitRot(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+	givenBitRot(t, filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "file.txt"), "jello")
+
+	report, err := handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Finding{{FINDING_BIT_ROT, SEVERITY_ERROR, "vol2", "file.txt",
+		"content does not match its hash, but the file has not been modified (bit rot)"}}, fileFindings(report))
+	assert.Equal(t, SEVERITY_ERROR, report.Worst())
+}
+
+func TestScrub_detectsReplicasWithDifferentContent(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1")
+	givenFileIn(&jbov, "file.txt", "bye", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	report, err := handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Len(t, fileFindings(report), 1)
+	assert.Equal(t, FINDING_REPLICAS_DIFFER, fileFindings(report)[0].Kind)
+	assert.Equal(t, "file.txt", fileFindings(report)[0].Path)
+}
+
+func TestScrub_detectsUnderReplicatedFiles(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	givenFileIn(&jbov, "notes.txt", "notes", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}, {Pattern: "*.txt", AtLeastACopyIn: "vol1"}}
+
+	report, err := handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Finding{
+		{FINDING_UNDER_REPLICATED, SEVERITY_ERROR, "", "film.mk4", "1 copies found, 2 required"},
+		{FINDING_UNDER_REPLICATED, SEVERITY_ERROR, "vol1", "notes.txt", "a copy is required in this volume"},
+	}, fileFindings(report))
+}
+
+func TestScrub_underReplicationIsAWarningWhileVolumesAreMissing(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	os.RemoveAll(jbov.Volumes["vol2"].LastMountPoint)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+
+	report, err := handle.Scrub(ScrubOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, SEVERITY_WARNING, report.Worst())
+	assert.Equal(t, FINDING_MISSING_VOLUME, report.Findings[0].Kind)
+	assert.Equal(t, FINDING_UNDER_REPLICATED, report.Findings[len(report.Findings)-1].Kind)
+}
+
+func TestScrub_sampleVerifiesAFractionAndCarriesOnNextTime(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	for _, path := range []string{"a", "b", "c", "d"} {
+		givenFileIn(&jbov, path, path, "vol1")
+	}
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	report, err := handle.Scrub(ScrubOptions{Sample: 0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Hashed)
+	assert.Equal(t, 2, report.Pending)
+
+	report, err = handle.Scrub(ScrubOptions{Sample: 0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Hashed)
+	assert.Equal(t, 0, report.Pending)
+
+	report, err = handle.Scrub(ScrubOptions{Sample: 0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Verified)
+	assert.Equal(t, 2, report.Pending)
+}
+
+func TestScrub_interruptedByMaxDurationResumes(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "file.txt", "hello", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	report, err := handle.Scrub(ScrubOptions{MaxDuration: time.Nanosecond})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Hashed)
+	assert.Equal(t, 2, report.Pending)
+
+	report, err = handle.Scrub(ScrubOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Hashed)
+	assert.Equal(t, 0, report.Pending)
+}
+
+func TestScrub_overdueReplicasAreAlwaysVerified(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	for _, path := range []string{"a", "b", "c", "d"} {
+		givenFileIn(&jbov, path, path, "vol1")
+	}
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+	givenVerifiedAgo(handle, "vol1", 48*time.Hour, "c", "d")
+
+	report, err := handle.Scrub(ScrubOptions{Sample: 0.01, MaxAge: 24 * time.Hour})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Verified)
+	volCatalog, _ := handle.Catalog("vol1")
+	assert.Equal(t, []string{"a", "b"}, volCatalog.Unverified(volCatalog.Pass))
+}
+
+func TestScrub_reportsOverdueReplicasLeftUnverified(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "a", "a", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+	givenVerifiedAgo(handle, "vol1", 48*time.Hour, "a")
+
+	report, err := handle.Scrub(ScrubOptions{MaxDuration: time.Nanosecond, MaxAge: 24 * time.Hour})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Finding{{FINDING_OVERDUE, SEVERITY_WARNING, "vol1", "a", "not verified for longer than 24h0m0s"}}, fileFindings(report))
+}
+
+// utility
+
+func givenFileIn(jbov *md.JBOV, path string, content string, cnames ...string) {
+	for _, cname := range cnames {
+		fullPath := filepath.Join(jbov.Volumes[cname].LastMountPoint, filepath.FromSlash(path))
+		os.MkdirAll(filepath.Dir(fullPath), 0755)
+		ioutil.WriteFile(fullPath, []byte(content), 0644)
+	}
+}
+
+func givenVerifiedAgo(handle *Handle, cname string, ago time.Duration, paths ...string) {
+	volCatalog, _ := handle.Catalog(cname)
+	for _, path := range paths {
+		volCatalog.Entries[path].Verified = time.Now().Add(-ago).UnixNano()
+	}
+	volCatalog.Save(handle.Volumes[cname].MountPoint)
+}
+
+// fileFindings leaves out the findings about volumes, test volumes share a filesystem and are warned about.
+func fileFindings(report *ScrubReport) []Finding {
+	findings := []Finding{}
+	for _, finding := range report.Findings {
+		if finding.Path != "" {
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// givenBitRot changes the file content keeping its size and times, as a decaying disk would.
+func givenBitRot(t *testing.T, path string, content string) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte(content), 0)
+	f.Close()
+	os.Chtimes(path, stat.ModTime(), stat.ModTime())
+}
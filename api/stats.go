@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/kuking/jbov/api/catalog"
+)
+
+// Summary describes the JBOV content, as found in the writable volumes: the distinct files and their size (every file
+// counted once), the replicas held, and the files with fewer copies than their rules require. Volumes holds the files
+// and bytes of every volume.
+type Summary struct {
+	Files           int
+	Bytes           int64
+	Replicas        int
+	UnderReplicated int
+	Volumes         map[string]*catalog.Stats
+}
+
+// Summary scans the writable volumes and summarises their content.
+func (handle *Handle) Summary() (*Summary, error) {
+	catalogs, stats, err := handle.Scan()
+	if err != nil {
+		return nil, err
+	}
+	summary := Summary{Volumes: stats}
+	holders := make(map[string][]string)
+	for cname, volCatalog := range catalogs {
+		for path, entry := range volCatalog.Entries {
+			if len(holders[path]) == 0 {
+				summary.Files++
+				summary.Bytes += entry.Size
+			}
+			holders[path] = append(holders[path], cname)
+			summary.Replicas++
+		}
+	}
+	for path, cnames := range holders {
+		if _, deleted := handle.Jbov.Deleted[path]; deleted {
+			continue
+		}
+		entry := catalogs[cnames[0]].Entries[path]
+		ncopies, copies, missingIn := handle.Jbov.Replication(path, entry.Size, entry.Mtime, cnames)
+		if copies < ncopies || len(missingIn) > 0 {
+			summary.UnderReplicated++
+		}
+	}
+	return &summary, nil
+}
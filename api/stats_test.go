@@ -0,0 +1,25 @@
+package api
+
+import (
+	"testing"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol2")
+	givenFileIn(&jbov, "notes.txt", "notes", "vol1", "vol2", "vol3")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*", Ncopies: md.ALL_VOLUMES}}
+
+	summary, err := handle.Summary()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.Files)
+	assert.Equal(t, int64(9), summary.Bytes)
+	assert.Equal(t, 5, summary.Replicas)
+	assert.Equal(t, 1, summary.UnderReplicated)
+	assert.Equal(t, 1, summary.Volumes["vol3"].Files)
+}
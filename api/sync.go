@@ -0,0 +1,179 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"github.com/kuking/jbov/api/catalog"
+	"github.com/kuking/jbov/api/hashing"
+)
+
+// PlanSync works out, for every file in the JBOV, the copies needed so it is held by as many non-deprecated volumes
+// as its rules require, and by the volumes its rules name. New copies go to the writable volumes holding the least
+// data. Files whose replicas differ or are damaged are not synced, they need a repair first.
+func (handle *Handle) PlanSync() (*Plan, error) {
+	catalogs, err := handle.scanWritable()
+	if err != nil {
+		return nil, err
+	}
+	return handle.planSync(catalogs), nil
+}
+
+// planSync plans the sync out of the catalogs given, up to date.
+func (handle *Handle) planSync(catalogs map[string]*catalog.Catalog) *Plan {
+	plan := handle.newPlan("sync", catalogs)
+	for _, warning := range handle.checkRules() {
+		plan.finding(Finding{FINDING_INVALID_RULE, SEVERITY_WARNING, "", "", warning.Detail})
+	}
+	used := make(map[string]int64)
+	holders := make(map[string][]string)
+	for cname, volCatalog := range catalogs {
+		for path, entry := range volCatalog.Entries {
+			holders[path] = append(holders[path], cname)
+			used[cname] += entry.Size
+		}
+	}
+	paths := []string{}
+	for path := range holders {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if _, deleted := handle.Jbov.Deleted[path]; deleted {
+			continue
+		}
+		cnames := holders[path]
+		sort.Strings(cnames)
+		source, ok := syncSource(path, cnames, catalogs)
+		if !ok {
+			plan.finding(Finding{FINDING_REPLICAS_DIFFER, SEVERITY_ERROR, "", path, "replicas differ or are damaged, repair it first"})
+			continue
+		}
+		held := make(map[string]bool)
+		for _, cname := range cnames {
+			held[cname] = true
+		}
+		entry := catalogs[source].Entries[path]
+		size, sum := entry.Size, entry.Hash
+
+		ncopies, copies, missingIn := handle.Jbov.Replication(path, entry.Size, entry.Mtime, cnames)
+		for _, cname := range missingIn {
+			if _, writable := catalogs[cname]; !writable {
+				plan.finding(Finding{FINDING_UNDER_REPLICATED, SEVERITY_WARNING, cname, path, "a copy is required in this volume, but it is not available"})
+				continue
+			}
+			plan.add(Op{OP_COPY, path, source, cname, size, sum, "required in this volume"})
+			held[cname] = true
+			used[cname] += size
+			if !handle.Jbov.Volumes[cname].Deprecated {
+				copies++
+			}
+		}
+		for copies < ncopies {
+			target := leastUsed(used, held, handle)
+			if target == "" {
+				plan.finding(Finding{FINDING_UNDER_REPLICATED, SEVERITY_WARNING, "", path, fmt.Sprintf("%d copies required, but only %d volumes can hold them", ncopies, copies)})
+				break
+			}
+			plan.add(Op{OP_COPY, path, source, target, size, sum, fmt.Sprintf("%d copies required", ncopies)})
+			held[target] = true
+			used[target] += size
+			copies++
+		}
+	}
+	handle.account(plan)
+	return plan
+}
+
+// syncSource returns the volume to copy a file from, the first (by cname) of its replicas; as long as all of them
+// were catalogued with the same content and none is damaged.
+func syncSource(path string, cnames []string, catalogs map[string]*catalog.Catalog) (string, bool) {
+	sum := ""
+	for _, cname := range cnames {
+		entry := catalogs[cname].Entries[path]
+		if entry.Damaged || (sum != "" && entry.Hash != "" && entry.Hash != sum) {
+			return "", false
+		}
+		if entry.Hash != "" {
+			sum = entry.Hash
+		}
+	}
+	return cnames[0], true
+}
+
+// leastUsed returns the writable, non-deprecated, volume not holding the file with the least data in it.
+func leastUsed(used map[string]int64, held map[string]bool, handle *Handle) string {
+	target := ""
+	for _, cname := range handle.Writable() {
+		if held[cname] || handle.Jbov.Volumes[cname].Deprecated {
+			continue
+		}
+		if target == "" || used[cname] < used[target] {
+			target = cname
+		}
+	}
+	return target
+}
+
+// copyReplica copies a file between volumes: it is written to a temporary file, verified against the source hash and
+// renamed into place; a copy never replaces a file already in the target.
+func (handle *Handle) copyReplica(op Op, catalogs map[string]*catalog.Catalog) error {
+	srcMountPoint := handle.Volumes[op.Source].MountPoint
+	src := filepath.Join(srcMountPoint, filepath.FromSlash(op.Path))
+	dst := filepath.Join(handle.Volumes[op.Target].MountPoint, filepath.FromSlash(op.Path))
+	if _, err := os.Lstat(dst); err == nil {
+		return errors.New(fmt.Sprintf("Can not copy %s, there is a file in its place in volume \"%s\" already", op.Path, op.Target))
+	}
+
+	algorithm := handle.Jbov.Hashing()
+	sum, _, err := catalogs[op.Source].Hash(srcMountPoint, op.Path, algorithm)
+	if err != nil {
+		return err
+	}
+	if op.Hash != "" && op.Hash != sum {
+		return errors.New(fmt.Sprintf("%s content in volume \"%s\" is not the one planned", op.Path, op.Source))
+	}
+	tmp, err := stageCopy(src, dst)
+	if err != nil {
+		return err
+	}
+	if copied, err := hashing.File(algorithm, tmp); err != nil || copied != sum {
+		os.Remove(tmp)
+		return errors.New(fmt.Sprintf("Copy of %s to volume \"%s\" does not verify, it has been discarded", op.Path, op.Target))
+	}
+	if err := rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	entry := catalog.EntryOf(info)
+	entry.Hash, entry.Verified = sum, time.Now().UnixNano()
+	catalogs[op.Target].Entries[op.Path] = entry
+	return nil
+}
+
+// scanWritable brings the catalogs of the writable volumes up to date, without saving them.
+func (handle *Handle) scanWritable() (map[string]*catalog.Catalog, error) {
+	catalogs := make(map[string]*catalog.Catalog)
+	for _, cname := range handle.Writable() {
+		previous, err := handle.Catalog(cname)
+		if err != nil {
+			return nil, err
+		}
+		if catalogs[cname], _, err = catalog.Scan(handle.Volumes[cname].MountPoint, previous); err != nil {
+			return nil, err
+		}
+	}
+	return catalogs, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanSync_copiesToTheVolumesRequiredByTheRules(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	givenFileIn(&jbov, "notes.txt", "notes", "vol1")
+	givenFileIn(&jbov, "big.bin", "a lot of data", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}, {Pattern: "*.txt", AtLeastACopyIn: "vol3"}}
+
+	plan, err := handle.PlanSync()
+
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Findings)
+	assert.Len(t, plan.Ops, 2)
+	assert.Equal(t, Op{OP_COPY, "film.mk4", "vol1", "vol3", 4, "", "2 copies required"}, plan.Ops[0])
+	assert.Equal(t, Op{OP_COPY, "notes.txt", "vol1", "vol3", 5, "", "required in this volume"}, plan.Ops[1])
+	assert.Equal(t, int64(9), plan.Bytes())
+	assert.Equal(t, []string{"vol3"}, plan.Cnames())
+	assert.Equal(t, int64(9), plan.Volumes["vol3"].Added)
+}
+
+func TestPlanSync_deprecatedVolumesDoNotCount(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Volumes["vol2"].Deprecated = true
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+
+	plan, err := handle.PlanSync()
+
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+	assert.Equal(t, []Finding{{FINDING_UNDER_REPLICATED, SEVERITY_WARNING, "", "film.mk4", "2 copies required, but only 1 volumes can hold them"}}, plan.Findings)
+}
+
+func TestPlanSync_refusesFilesWithDifferingReplicas(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	givenFileIn(&jbov, "film.mk4", "other", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Scrub(ScrubOptions{})
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 3}}
+
+	plan, err := handle.PlanSync()
+
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Ops)
+	assert.Equal(t, FINDING_REPLICAS_DIFFER, plan.Findings[0].Kind)
+}
+
+func TestApply_syncCopiesAndVerifies(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "dir/film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+	plan, _ := handle.PlanSync()
+
+	done, err := handle.Apply(plan)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, done)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "dir", "film.mk4"))
+	assert.Equal(t, "film", string(content))
+	volCatalog, _ := handle.Catalog("vol2")
+	assert.NotEmpty(t, volCatalog.Entries["dir/film.mk4"].Hash)
+	assert.Equal(t, []string{"dir/film.mk4"}, volCatalog.Paths())
+	assert.False(t, handle.IsLocked())
+
+	plan, _ = handle.PlanSync()
+	assert.Empty(t, plan.Ops)
+}
+
+func TestApply_syncRefusesWhenTheFilesChanged(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: 2}}
+	plan, _ := handle.PlanSync()
+	givenFileIn(&jbov, "film.mk4", "meanwhile", "vol2")
+
+	done, err := handle.Apply(plan)
+
+	assert.EqualError(t, err, "Volume \"vol2\" files changed since the plan was made, plan it again")
+	assert.Equal(t, 0, done)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "film.mk4"))
+	assert.Equal(t, "meanwhile", string(content))
+	leftovers, _ := filepath.Glob(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, ".jbov.tmp-*"))
+	assert.Empty(t, leftovers)
+}
+
+func TestPlanSync_copyInEveryVolume(t *testing.T) {
+	jbov := givenThreeVolumesJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Rules = []md.Rule{{Pattern: "*.mk4", Ncopies: md.ALL_VOLUMES}}
+
+	plan, _ := handle.PlanSync()
+	assert.Len(t, plan.Ops, 2)
+
+	handle.Jbov.Volumes["vol3"].Deprecated = true
+	plan, _ = handle.PlanSync()
+	assert.Equal(t, []Op{{OP_COPY, "film.mk4", "vol1", "vol2", 4, "", "2 copies required"}}, plan.Ops)
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"github.com/kuking/jbov/api/md"
+)
+
+// TombstoneRetention is how long a deletion waits for the volumes pending it to come back, past it the tombstone is
+// dropped and the copies left in those volumes are no longer deleted.
+var TombstoneRetention = 365 * 24 * time.Hour
+
+// Remove deletes a file from the JBOV, given its path. A tombstone is recorded, with every volume pending and the
+// content removed, and the file is moved to the trash of the writable volumes; the rest (missing ones, or with problems)
+// stay pending and the file is deleted from them once they are back. The metadata is written once, afterwards; were it
+// interrupted before, the file is still in the trash. It returns the volumes the file has been deleted from.
+func (handle *Handle) Remove(path string) ([]string, error) {
+	path, err := handle.RelativePath(path)
+	if err != nil {
+		return nil, err
+	}
+	holders, err := handle.Holders(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := handle.Lock("rm")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	pending := []string{}
+	for cname := range handle.Jbov.Volumes {
+		pending = append(pending, cname)
+	}
+	sort.Strings(pending)
+	if handle.Jbov.Deleted == nil {
+		handle.Jbov.Deleted = make(map[string]*md.Deleted)
+	}
+	deleted := &md.Deleted{Ts: int(time.Now().Unix()), Pending: pending}
+	if len(holders) > 0 {
+		deleted.Size, deleted.Hash = handle.contentOf(holders[0], path)
+	}
+	handle.Jbov.Deleted[path] = deleted
+	handle.buryTombstones()
+	if err := handle.Save(); err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, cname := range holders {
+		if _, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path))); os.IsNotExist(err) {
+			removed = append(removed, cname)
+		}
+	}
+	if _, _, err := handle.purgeTrashRetention(); err != nil {
+		return removed, err
+	}
+	if len(removed) < len(holders) {
+		return removed, errors.New(fmt.Sprintf("%s could not be deleted from every volume, it is still pending", path))
+	}
+	return removed, nil
+}
+
+// Holders returns the writable volumes holding the file to remove, as found in them; it is an error when it is a
+// directory, or when it is not found and every volume is writable (the others might hold it).
+func (handle *Handle) Holders(path string) ([]string, error) {
+	path, err := handle.RelativePath(path)
+	if err != nil {
+		return nil, err
+	}
+	holders := []string{}
+	for _, cname := range handle.Writable() {
+		info, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path)))
+		if err == nil && info.IsDir() {
+			return nil, errors.New(fmt.Sprintf("%s is a directory, only files can be removed", path))
+		}
+		if err == nil {
+			holders = append(holders, cname)
+		}
+	}
+	if len(holders) == 0 && len(handle.Writable()) == len(handle.Jbov.Volumes) {
+		return nil, errors.New(fmt.Sprintf("%s not found in any volume", path))
+	}
+	return holders, nil
+}
+
+// applyTombstones buries the files removed from the JBOV and writes the metadata, if anything changed.
+func (handle *Handle) applyTombstones() error {
+	if !handle.buryTombstones() {
+		return nil
+	}
+	return handle.Save()
+}
+
+// buryTombstones moves to the trash the files removed from the JBOV in the writable volumes pending it. A copy modified
+// after the deletion is a new file, it is kept. Tombstones no longer pending, or older than TombstoneRetention, are
+// dropped; and so are the ones of a path that reappeared, with other content, in a volume no longer pending it. It
+// tells whether the tombstones changed, the metadata is not written.
+func (handle *Handle) buryTombstones() bool {
+	if len(handle.Jbov.Deleted) == 0 {
+		return false
+	}
+	writable := make(map[string]bool)
+	for _, cname := range handle.Writable() {
+		writable[cname] = true
+	}
+	expired := time.Now().Add(-TombstoneRetention).Unix()
+	trashId := newTrashId()
+	changed := false
+	for path, deleted := range handle.Jbov.Deleted {
+		pending := []string{}
+		for _, cname := range deleted.Pending {
+			if !writable[cname] || !handle.bury(cname, path, trashId, deleted) {
+				pending = append(pending, cname)
+			}
+		}
+		if len(pending) != len(deleted.Pending) {
+			deleted.Pending = pending
+			changed = true
+		}
+		if len(deleted.Pending) == 0 || int64(deleted.Ts) < expired || handle.reappeared(path, deleted) {
+			delete(handle.Jbov.Deleted, path)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// bury moves to the trash a file of a volume with a tombstone, unless it is newer than it; it tells whether it is done
+// with the file.
+func (handle *Handle) bury(cname string, path string, trashId string, deleted *md.Deleted) bool {
+	info, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path)))
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	if info.IsDir() || info.ModTime().Unix() > int64(deleted.Ts) {
+		return true
+	}
+	_, err = handle.trash(cname, trashId, path)
+	return err == nil
+}
+
+// contentOf returns the size and hash of a file in a volume, the hash is empty when it can not be read.
+func (handle *Handle) contentOf(cname string, path string) (int64, string) {
+	info, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path)))
+	if err != nil {
+		return 0, ""
+	}
+	sum, err := handle.replicaHash(cname, path)
+	if err != nil {
+		return info.Size(), ""
+	}
+	return info.Size(), sum
+}
+
+// reappeared tells whether a writable volume no longer pending a tombstone holds a file in its path with content other
+// than the one removed; without a hash for it, only the size tells them apart.
+func (handle *Handle) reappeared(path string, deleted *md.Deleted) bool {
+	pending := make(map[string]bool)
+	for _, cname := range deleted.Pending {
+		pending[cname] = true
+	}
+	for _, cname := range handle.Writable() {
+		if pending[cname] {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(handle.Volumes[cname].MountPoint, filepath.FromSlash(path)))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.Size() != deleted.Size {
+			return true
+		}
+		if deleted.Hash == "" {
+			continue
+		}
+		if sum, err := handle.replicaHash(cname, path); err == nil && sum != deleted.Hash {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"testing"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemove_deletesTheFileFromEveryVolume(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "dir/film.mk4", "film", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	generation := handle.Jbov.Generation
+
+	removed, err := handle.Remove("dir/film.mk4")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1", "vol2"}, removed)
+	assert.Equal(t, generation+1, handle.Jbov.Generation)
+	for _, vol := range jbov.Volumes {
+		_, err := os.Stat(filepath.Join(vol.LastMountPoint, "dir", "film.mk4"))
+		assert.True(t, os.IsNotExist(err))
+	}
+	assert.Empty(t, handle.Jbov.Deleted)
+	assert.False(t, handle.IsLocked())
+}
+
+func TestRemove_leavesTheMissingVolumesPending(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol2")
+	offline := jbov.Volumes["vol2"].LastMountPoint + "-offline"
+	os.Rename(jbov.Volumes["vol2"].LastMountPoint, offline)
+	defer os.RemoveAll(offline)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	removed, err := handle.Remove("film.mk4")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol1"}, removed)
+	assert.Equal(t, []string{"vol2"}, handle.Jbov.Deleted["film.mk4"].Pending)
+
+	os.Rename(offline, jbov.Volumes["vol2"].LastMountPoint)
+	handle, err = Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "film.mk4"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, handle.Jbov.Deleted)
+}
+
+func TestRemove_notFound(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	_, err := handle.Remove("nothing.txt")
+
+	assert.EqualError(t, err, "nothing.txt not found in any volume")
+}
+
+func TestApplyTombstones_keepsFilesNewerThanTheDeletion(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "new film", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenTombstone(handle, "film.mk4", time.Now().Add(-time.Hour), "vol2")
+
+	assert.NoError(t, handle.applyTombstones())
+
+	_, err := os.Stat(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "film.mk4"))
+	assert.NoError(t, err)
+	assert.Empty(t, handle.Jbov.Deleted)
+}
+
+func TestApplyTombstones_dropsTheExpiredOnes(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	os.RemoveAll(jbov.Volumes["vol2"].LastMountPoint)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenTombstone(handle, "old.mk4", time.Now().Add(-TombstoneRetention-time.Hour), "vol2")
+	givenTombstone(handle, "recent.mk4", time.Now(), "vol2")
+
+	assert.NoError(t, handle.applyTombstones())
+
+	assert.NotContains(t, handle.Jbov.Deleted, "old.mk4")
+	assert.Equal(t, []string{"vol2"}, handle.Jbov.Deleted["recent.mk4"].Pending)
+}
+
+func TestApplyTombstones_dropsTheOneOfAPathReappearingWithOtherContent(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol2")
+	offline := jbov.Volumes["vol2"].LastMountPoint + "-offline"
+	os.Rename(jbov.Volumes["vol2"].LastMountPoint, offline)
+	defer os.RemoveAll(offline)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Remove("film.mk4")
+	givenFileIn(&jbov, "film.mk4", "another film", "vol1")
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "film.mk4"), old, old)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Empty(t, handle.Jbov.Deleted)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "film.mk4"))
+	assert.Equal(t, "another film", string(content))
+}
+
+func TestApplyTombstones_keepsTheOneOfAPathReappearingWithTheSameContent(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol2")
+	offline := jbov.Volumes["vol2"].LastMountPoint + "-offline"
+	os.Rename(jbov.Volumes["vol2"].LastMountPoint, offline)
+	defer os.RemoveAll(offline)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Remove("film.mk4")
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "film.mk4"), old, old)
+
+	handle, err := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol2"}, handle.Jbov.Deleted["film.mk4"].Pending)
+	assert.Equal(t, int64(4), handle.Jbov.Deleted["film.mk4"].Size)
+}
+
+// utility
+
+func givenTombstone(handle *Handle, path string, ts time.Time, pending ...string) {
+	if handle.Jbov.Deleted == nil {
+		handle.Jbov.Deleted = make(map[string]*md.Deleted)
+	}
+	handle.Jbov.Deleted[path] = &md.Deleted{Ts: int(ts.Unix()), Pending: pending}
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// TRASH_DIRNAME keeps, in every volume, the files deleted or overwritten by jbov until purged; under a directory per
+// operation, named after when it happened (TRASH_ID_FORMAT, UTC), with the files at their path.
+const TRASH_DIRNAME = ".jbov.trash"
+
+const TRASH_ID_FORMAT = "20060102T150405.000000000"
+
+// TrashEntry is a file in the trash of a volume, Id is the operation which trashed it. Quarantined entries are bad
+// replicas replaced by a repair, they are never purged by the trash retention.
+type TrashEntry struct {
+	Cname       string
+	Id          string
+	Path        string
+	Size        int64
+	Trashed     time.Time
+	Quarantined bool
+}
+
+func (entry TrashEntry) String() string {
+	if entry.Quarantined {
+		return fmt.Sprintf("%s in volume \"%s\", quarantined by repair %s (%s)", entry.Path, entry.Cname, entry.Trashed.Local().Format(time.RFC3339), entry.Id)
+	}
+	return fmt.Sprintf("%s in volume \"%s\", trashed %s (%s)", entry.Path, entry.Cname, entry.Trashed.Local().Format(time.RFC3339), entry.Id)
+}
+
+func newTrashId() string {
+	return time.Now().UTC().Format(TRASH_ID_FORMAT)
+}
+
+// trash moves a file of a volume, given its path, into the volume trash; it returns where it has been moved to.
+func (handle *Handle) trash(cname string, id string, path string) (string, error) {
+	mountPoint := handle.Volumes[cname].MountPoint
+	fullPath := filepath.Join(mountPoint, filepath.FromSlash(path))
+	trashed := filepath.Join(mountPoint, TRASH_DIRNAME, id, filepath.FromSlash(path))
+	if _, err := os.Lstat(trashed); err == nil {
+		return "", errors.New(fmt.Sprintf("%s is in the trash of volume \"%s\" already", path, cname))
+	}
+	if err := os.MkdirAll(filepath.Dir(trashed), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(fullPath, trashed); err != nil {
+		return "", err
+	}
+	return trashed, syncDir(filepath.Dir(fullPath))
+}
+
+// Trash lists the files in the trash of every writable volume, the most recently trashed first.
+func (handle *Handle) Trash() ([]TrashEntry, error) {
+	entries := []TrashEntry{}
+	for _, cname := range handle.Writable() {
+		trashed, err := handle.trashOf(cname)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, trashed...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Id != entries[j].Id {
+			return entries[i].Id > entries[j].Id
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// trashOf lists the files in the trash of a volume, the oldest first.
+func (handle *Handle) trashOf(cname string) ([]TrashEntry, error) {
+	trashDir := filepath.Join(handle.Volumes[cname].MountPoint, TRASH_DIRNAME)
+	infos, err := ioutil.ReadDir(trashDir)
+	if os.IsNotExist(err) {
+		return []TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	quarantined, err := quarantinedIn(handle.Volumes[cname].MountPoint)
+	if err != nil {
+		return nil, err
+	}
+	entries := []TrashEntry{}
+	for _, info := range infos {
+		trashed, err := time.Parse(TRASH_ID_FORMAT, info.Name())
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		idDir := filepath.Join(trashDir, info.Name())
+		err = filepath.Walk(idDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(idDir, path)
+			if err != nil {
+				return err
+			}
+			id := filepath.Base(idDir)
+			entries = append(entries, TrashEntry{cname, id, filepath.ToSlash(rel), info.Size(), trashed, quarantined[id+"/"+filepath.ToSlash(rel)]})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// RestoreTrash moves a trashed file back into place, given its path: the most recently trashed copy in every volume, or
+// the one trashed by the given operation id. A file in its place is never replaced. A pending deletion of the file is
+// undone, so the copies in the volumes still pending it are kept. It returns the copies restored.
+func (handle *Handle) RestoreTrash(path string, id string) ([]TrashEntry, error) {
+	path, err := handle.RelativePath(path)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := handle.Lock("trash restore")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	restored := []TrashEntry{}
+	for _, cname := range handle.Writable() {
+		entries, err := handle.trashOf(cname)
+		if err != nil {
+			return restored, err
+		}
+		var latest *TrashEntry
+		for i := range entries {
+			if entries[i].Path == path && (id == "" || entries[i].Id == id) {
+				latest = &entries[i]
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		mountPoint := handle.Volumes[cname].MountPoint
+		dst := filepath.Join(mountPoint, filepath.FromSlash(path))
+		if _, err := os.Lstat(dst); err == nil {
+			return restored, errors.New(fmt.Sprintf("Can not restore %s, there is a file in its place in volume \"%s\"", path, cname))
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return restored, err
+		}
+		idDir := filepath.Join(mountPoint, TRASH_DIRNAME, latest.Id)
+		if err := os.Rename(filepath.Join(idDir, filepath.FromSlash(path)), dst); err != nil {
+			return restored, err
+		}
+		pruneEmptyDirs(idDir)
+		restored = append(restored, *latest)
+	}
+	if len(restored) == 0 {
+		return nil, errors.New(fmt.Sprintf("%s is not in the trash", path))
+	}
+	if _, deleted := handle.Jbov.Deleted[path]; deleted {
+		delete(handle.Jbov.Deleted, path)
+		return restored, handle.Save()
+	}
+	return restored, nil
+}
+
+// EmptyTrash purges the files in the trash of every writable volume, all of them or the ones trashed longer ago than
+// the given time. It returns the number of files and bytes purged.
+func (handle *Handle) EmptyTrash(olderThan time.Duration) (int, int64, error) {
+	lock, err := handle.Lock("trash empty")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lock.Release()
+	before := time.Now().Add(-olderThan)
+	return handle.purgeTrash(true, func(entry TrashEntry, _ int64) bool { return !entry.Trashed.After(before) })
+}
+
+// purgeTrashRetention purges the files past the trash retention configured: trashed longer ago than its max age and,
+// the oldest first, the ones over its max size in every volume. Quarantined files are kept, and not accounted.
+func (handle *Handle) purgeTrashRetention() (int, int64, error) {
+	retention := handle.Jbov.TrashRetention()
+	before := time.Now().Add(-time.Duration(retention.MaxAgeDays) * 24 * time.Hour)
+	return handle.purgeTrash(false, func(entry TrashEntry, newer int64) bool {
+		return (retention.MaxAgeDays > 0 && entry.Trashed.Before(before)) || (retention.MaxBytes > 0 && newer+entry.Size > retention.MaxBytes)
+	})
+}
+
+// purgeTrash deletes, for good, the trashed files the given function tells to, quarantined ones only if told to as
+// well; it is told about the bytes trashed after the file, in the same volume.
+func (handle *Handle) purgeTrash(quarantined bool, purge func(entry TrashEntry, newer int64) bool) (int, int64, error) {
+	files, bytes := 0, int64(0)
+	for _, cname := range handle.Writable() {
+		entries, err := handle.trashOf(cname)
+		if err != nil {
+			return files, bytes, err
+		}
+		trashDir := filepath.Join(handle.Volumes[cname].MountPoint, TRASH_DIRNAME)
+		newer := int64(0)
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			if entry.Quarantined && !quarantined {
+				continue
+			}
+			if !purge(entry, newer) {
+				newer += entry.Size
+				continue
+			}
+			if err := os.Remove(filepath.Join(trashDir, entry.Id, filepath.FromSlash(entry.Path))); err != nil {
+				return files, bytes, err
+			}
+			files++
+			bytes += entry.Size
+		}
+		for _, entry := range entries {
+			pruneEmptyDirs(filepath.Join(trashDir, entry.Id))
+		}
+	}
+	return files, bytes, nil
+}
+
+// pruneEmptyDirs removes the directories left empty under dir, dir included; it tells whether dir has been removed.
+func pruneEmptyDirs(dir string) bool {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	empty := true
+	for _, info := range infos {
+		if !info.IsDir() || !pruneEmptyDirs(filepath.Join(dir, info.Name())) {
+			empty = false
+		}
+	}
+	return empty && os.Remove(dir) == nil
+}
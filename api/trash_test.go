@@ -0,0 +1,109 @@
+package api
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"github.com/kuking/jbov/api/md"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemove_movesTheFileToTheTrash(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "dir/film.mk4", "film", "vol1", "vol2")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+
+	handle.Remove("dir/film.mk4")
+	entries, err := handle.Trash()
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "dir/film.mk4", entries[0].Path)
+	assert.Equal(t, int64(4), entries[0].Size)
+	_, stats, _ := handle.Scan()
+	assert.Equal(t, 0, stats["vol1"].Files)
+}
+
+func TestRestoreTrash_undoesTheDeletion(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	defer givenSearchDirs([]string{})()
+	givenFileIn(&jbov, "film.mk4", "film", "vol1", "vol2")
+	offline := jbov.Volumes["vol2"].LastMountPoint + "-offline"
+	os.Rename(jbov.Volumes["vol2"].LastMountPoint, offline)
+	defer os.RemoveAll(offline)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Remove("film.mk4")
+
+	restored, err := handle.RestoreTrash("film.mk4", "")
+
+	assert.NoError(t, err)
+	assert.Len(t, restored, 1)
+	content, _ := ioutil.ReadFile(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, "film.mk4"))
+	assert.Equal(t, "film", string(content))
+	assert.Empty(t, handle.Jbov.Deleted)
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol1"].LastMountPoint, TRASH_DIRNAME, restored[0].Id))
+	assert.True(t, os.IsNotExist(err))
+
+	os.Rename(offline, jbov.Volumes["vol2"].LastMountPoint)
+	Open(jbov.Volumes["vol1"].LastMountPoint)
+	_, err = os.Stat(filepath.Join(jbov.Volumes["vol2"].LastMountPoint, "film.mk4"))
+	assert.NoError(t, err)
+}
+
+func TestRestoreTrash_neverReplacesAFile(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	givenFileIn(&jbov, "film.mk4", "film", "vol1")
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Remove("film.mk4")
+	givenFileIn(&jbov, "film.mk4", "new film", "vol1")
+
+	_, err := handle.RestoreTrash("film.mk4", "")
+
+	assert.EqualError(t, err, "Can not restore film.mk4, there is a file in its place in volume \"vol1\"")
+}
+
+func TestEmptyTrash_purgesOnlyTheOlderFiles(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	givenTrashed(&jbov, "vol1", time.Now().Add(-48*time.Hour), "old.txt", "old")
+	givenTrashed(&jbov, "vol1", time.Now(), "new.txt", "new")
+
+	files, bytes, err := handle.EmptyTrash(24 * time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, files)
+	assert.Equal(t, int64(3), bytes)
+	entries, _ := handle.Trash()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "new.txt", entries[0].Path)
+}
+
+func TestPurgeTrashRetention_byAgeAndSize(t *testing.T) {
+	jbov := givenCreatedJBOV(t)
+	defer cleanupMountPoints(&jbov)
+	handle, _ := Open(jbov.Volumes["vol1"].LastMountPoint)
+	handle.Jbov.Trash = &md.TrashRetention{MaxAgeDays: 7, MaxBytes: 10}
+	givenTrashed(&jbov, "vol1", time.Now().Add(-8*24*time.Hour), "ancient.txt", "a")
+	givenTrashed(&jbov, "vol1", time.Now().Add(-2*time.Hour), "older.txt", "0123456")
+	givenTrashed(&jbov, "vol1", time.Now().Add(-time.Hour), "newer.txt", "0123456")
+
+	files, _, err := handle.purgeTrashRetention()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, files)
+	entries, _ := handle.Trash()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "newer.txt", entries[0].Path)
+}
+
+// utility
+
+func givenTrashed(jbov *md.JBOV, cname string, trashed time.Time, path string, content string) {
+	givenFileIn(jbov, filepath.Join(TRASH_DIRNAME, trashed.UTC().Format(TRASH_ID_FORMAT), path), content, cname)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"github.com/kuking/jbov/api/md"
+)
+
+// MAX_SNAPSHOTS is the number of past metadata versions kept in every volume, so forked copies can be merged.
+const MAX_SNAPSHOTS = 16
+
+// Save records a new edit of the in-memory metadata and writes it onto every present volume, but the ones with
+// consistency problems.
+func (handle *Handle) Save() error {
+	handle.Jbov.Touch()
+	return handle.writeOnto(handle.Present())
+}
+
+// writeOnto writes the in-memory metadata, as it is, onto the given volumes, either onto all of them or onto none.
+// Volumes with consistency problems are never written.
+func (handle *Handle) writeOnto(cnames []string) error {
+	writable := make(map[string]bool)
+	for _, cname := range handle.Writable() {
+		writable[cname] = true
+	}
+	mountPoints := []string{}
+	for _, cname := range cnames {
+		if writable[cname] {
+			mountPoints = append(mountPoints, handle.Volumes[cname].MountPoint)
+		}
+	}
+	if len(mountPoints) == 0 {
+		return nil
+	}
+	return writeMetadata(handle.Jbov, mountPoints, nil)
+}
+
+// writeMetadata signs (if there is a key for it) and writes the metadata onto every mount point, plus the extra files
+// given (by path), in a single transaction. Once written, a snapshot of it is kept for the last MAX_SNAPSHOTS edits.
+func writeMetadata(jbov *md.JBOV, mountPoints []string, extra map[string][]byte) error {
+	key, err := ReadKey(jbov.Uniqid)
+	if err != nil {
+		return err
+	}
+	if err := signWith(jbov, key); err != nil {
+		return err
+	}
+	jsonb, err := jbov.Marshal()
+	if err != nil {
+		return err
+	}
+	filemode64, _ := strconv.ParseUint("744", 8, 32)
+	filemode := os.FileMode(filemode64)
+
+	tx := transaction{}
+	for _, mountPoint := range mountPoints {
+		if err := tx.stage(filepath.Join(mountPoint, md.JBOV_FNAME), jsonb, filemode); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+	for path, content := range extra {
+		if err := tx.stage(path, content, filemode); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+
+	for _, mountPoint := range mountPoints {
+		if err := writeSnapshot(mountPoint, jbov, jsonb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSnapshot(mountPoint string, jbov *md.JBOV, jsonb []byte) error {
+	if jbov.Head() == "" {
+		return nil
+	}
+	historyDir := filepath.Join(mountPoint, md.HISTORY_DIRNAME)
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(historyDir, snapshotFname(jbov.Head())), jsonb, 0644); err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for i := len(jbov.History) - 1; i >= 0 && i >= len(jbov.History)-MAX_SNAPSHOTS; i-- {
+		keep[snapshotFname(jbov.History[i].Id)] = true
+	}
+	infos, err := ioutil.ReadDir(historyDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !keep[info.Name()] {
+			os.Remove(filepath.Join(historyDir, info.Name()))
+		}
+	}
+	return nil
+}
+
+func snapshotFname(editId string) string {
+	return strings.TrimPrefix(editId, "EDIT:") + ".json"
+}
+
+// readSnapshot looks for the metadata as it was after the given edit in the history of every present volume.
+func (handle *Handle) readSnapshot(editId string) *md.JBOV {
+	if editId == "" {
+		return nil
+	}
+	for _, cname := range handle.Present() {
+		jsonb, err := ioutil.ReadFile(filepath.Join(handle.Volumes[cname].MountPoint, md.HISTORY_DIRNAME, snapshotFname(editId)))
+		if err != nil {
+			continue
+		}
+		if jbov, _, err := md.Load(jsonb); err == nil {
+			return jbov
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"github.com/spf13/cobra"
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Updates the catalog of files held by every volume",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle := OpenOrEnd()
+		_, stats, err := handle.Scan()
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		cnames := []string{}
+		for cname := range stats {
+			cnames = append(cnames, cname)
+		}
+		sort.Strings(cnames)
+		for _, cname := range cnames {
+			stat := stats[cname]
+			fmt.Printf("%s: %d file(s), %d byte(s); %d added, %d changed, %d removed, %d unchanged\n",
+				cname, stat.Files, stat.Bytes, stat.Added, stat.Changed, stat.Removed, stat.Unchanged)
+		}
+	},
+}
+
+var hashCmd = &cobra.Command{
+	Use:   "hash path",
+	Short: "Prints the content hash of every replica of a file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrAndEnd(-1, "you need to indicate the file to hash.")
+		}
+		handle := OpenOrEnd()
+		hashes, err := handle.ReplicaHashes(args[0])
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		if len(hashes) == 0 {
+			ErrAndEnd(-1, fmt.Sprintf("%s not found in any volume.", args[0]))
+		}
+		distinct := make(map[string]bool)
+		for _, cname := range handle.Writable() {
+			if sum, ok := hashes[cname]; ok {
+				fmt.Printf("%s: %s\n", cname, sum)
+				distinct[sum] = true
+			} else {
+				fmt.Printf("%s: -\n", cname)
+			}
+		}
+		if len(distinct) > 1 {
+			fmt.Println("Warning: replicas differ")
+			os.Exit(2)
+		}
+	},
+}
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Replaces the bad replicas found by check with a good copy, quarantining them",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle := OpenOrEnd()
+		if resume {
+			ResumeOrEnd(handle, "repair")
+			return
+		}
+		plan, err := handle.PlanRepair()
+		RunPlanOrEnd(handle, plan, err)
+	},
+}
+
+func RegisterCatalogCommands(rootCmd *cobra.Command) {
+	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(hashCmd)
+	rootCmd.AddCommand(repairCmd)
+}
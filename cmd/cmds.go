@@ -2,22 +2,91 @@ package cmd
 
 import (
 	"log"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kuking/jbov"
+	"github.com/kuking/jbov/api"
 	"github.com/spf13/cobra"
 )
 
 var Verbose bool
 var YesMan bool
 var DryRun bool
+var JbovPath string
+var forceUnlock bool
+var scrubSample, scrubMaxDuration, scrubMaxAge string
 
 func ErrAndEnd(exitcode int, msg string) {
 	fmt.Println("Error:", msg)
 	os.Exit(exitcode)
 }
 
+// OpenOrEnd opens the jbov indicated by the --jbov flag, or ends with an error. It warns when the metadata signature
+// could not be verified.
+func OpenOrEnd() *api.Handle {
+	handle, err := api.Open(JbovPath)
+	if err != nil {
+		ErrAndEnd(-1, err.Error())
+	}
+	for _, warning := range handle.Warnings {
+		if warning.Kind == api.PROBLEM_UNVERIFIED_SIGNATURE {
+			fmt.Println("Warning:", warning)
+		}
+	}
+	return handle
+}
+
+// printRuleWarnings tells about the rules not well formed, they match nothing.
+func printRuleWarnings(handle *api.Handle) {
+	for _, warning := range handle.Warnings {
+		if warning.Kind == api.PROBLEM_INVALID_RULE {
+			fmt.Println("Warning:", warning)
+		}
+	}
+}
+
+// LockOrEnd locks the jbov for a mutating operation, or ends with an error.
+func LockOrEnd(handle *api.Handle, operation string) *api.Lock {
+	lock, err := handle.Lock(operation)
+	if err != nil {
+		ErrAndEnd(-1, err.Error())
+	}
+	return lock
+}
+
+// parsePercentage parses "5%" (or "5") as 0.05, an empty string is 0.
+func parsePercentage(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percentage <= 0 || percentage > 100 {
+		return 0, errors.New(fmt.Sprintf("invalid percentage \"%s\", expected something like 5%%", value))
+	}
+	return percentage / 100, nil
+}
+
+// parseDuration parses durations as time.ParseDuration does, plus days (i.e. 30d); an empty string is 0.
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err == nil && days > 0 {
+			return time.Duration(days * float64(24*time.Hour)), nil
+		}
+	} else if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return duration, nil
+	}
+	return 0, errors.New(fmt.Sprintf("invalid duration \"%s\", expected something like 2h or 30d", value))
+}
+
 func RegisterCommands() {
 	RootCmd.AddCommand(versionCmd)
 	RootCmd.AddCommand(mountCmd)
@@ -25,14 +94,26 @@ func RegisterCommands() {
 	RootCmd.AddCommand(syncCmd)
 	RootCmd.AddCommand(setCmd)
 	RootCmd.AddCommand(statsCmd)
-	RootCmd.AddCommand(rebalanceCmd)
+	RootCmd.AddCommand(unlockCmd)
 
 	RegisterCreateCommands(RootCmd)
 	RegisterRuleCommands(RootCmd)
+	RegisterMetadataCommands(RootCmd)
+	RegisterCatalogCommands(RootCmd)
+	RegisterPlanCommands(RootCmd)
+	RegisterFileCommands(RootCmd)
+
+	checkCmd.Flags().StringVar(&scrubSample, "sample", "", "Verifies only this percentage of every volume files, i.e. 5%")
+	checkCmd.Flags().StringVar(&scrubMaxDuration, "max-duration", "", "Stops verifying files after this long, i.e. 2h")
+	checkCmd.Flags().StringVar(&scrubMaxAge, "max-age", "", "Always verifies files not verified for this long, i.e. 30d")
+	unlockCmd.Flags().BoolVar(&forceUnlock, "force", false, "Removes the locks even if their holder seems to be alive (dangerous)")
 
 	RootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "Verbose output")
 	RootCmd.PersistentFlags().BoolVarP(&YesMan, "yes", "y", false, "Automatically answers yes (dangerous)")
-	RootCmd.PersistentFlags().BoolVarP(&YesMan, "dry-run", "n", false, "Shows what would be done, without applying any change.")
+	RootCmd.PersistentFlags().BoolVarP(&DryRun, "dry-run", "n", false, "Shows what would be done, without applying any change.")
+	RootCmd.PersistentFlags().StringVarP(&JbovPath, "jbov", "j", ".", "Mount point of any of the jbov volumes")
+	RootCmd.PersistentFlags().StringVar(&api.KeysDir, "keys-dir", api.KeysDir, "Directory holding the JBOV signing keys, JBOV_KEYS when set")
+	RootCmd.PersistentFlags().StringSliceVar(&api.SearchDirs, "search-dir", api.SearchDirs, "Directories to scan for volumes which are not in their last known mount point")
 }
 
 // DoitCmd is the base command.
@@ -59,17 +140,63 @@ var mountCmd = &cobra.Command{
 
 var checkCmd = &cobra.Command{
 	Use:   "check",
-	Short: "Checks a jbov",
+	Short: "Checks a jbov: metadata, bit rot, differing replicas and redundancy rules",
+	Long: `Checks a jbov: metadata consistency across volumes, the content of every file against its stored hash (bit
+rot), that every replica of a file is identical and that files are as replicated as the rules require.
+Replicas are verified least recently verified first and the progress is saved as it goes, so an interrupted or
+limited (--sample, --max-duration) check carries on where it stopped the next time. Use --max-age to guarantee every
+replica is verified at least that often.
+Exits with 0 when everything is fine, 1 when there are warnings and 2 when there are errors.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		options := api.ScrubOptions{}
+		var err error
+		if options.Sample, err = parsePercentage(scrubSample); err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		if options.MaxDuration, err = parseDuration(scrubMaxDuration); err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		if options.MaxAge, err = parseDuration(scrubMaxAge); err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		handle := OpenOrEnd()
+		report, err := handle.Scrub(options)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		for _, finding := range report.Findings {
+			if finding.Severity > api.SEVERITY_INFO || Verbose {
+				fmt.Printf("%s: %s\n", finding.Severity, finding)
+			}
+		}
+		fmt.Printf("Checked %d file(s), %d replica(s), %d byte(s): %d verified, %d hashed for the first time.\n",
+			report.Files, report.Replicas, report.Bytes, report.Verified, report.Hashed)
+		if report.Pending > 0 {
+			fmt.Printf("%d replica(s) pending verification, the next check carries on with them.\n", report.Pending)
+		}
+		switch report.Worst() {
+		case api.SEVERITY_ERROR:
+			fmt.Printf("%d error(s), %d warning(s).\n", report.Count(api.SEVERITY_ERROR), report.Count(api.SEVERITY_WARNING))
+			os.Exit(2)
+		case api.SEVERITY_WARNING:
+			fmt.Printf("%d warning(s).\n", report.Count(api.SEVERITY_WARNING))
+			os.Exit(1)
+		}
+		fmt.Println("OK")
 	},
 }
 
 var syncCmd = &cobra.Command{
 	Use:   "sync",
-	Short: "Sync a jbov",
+	Short: "Copies files to the volumes needing them, as the redundancy rules require",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		handle := OpenOrEnd()
+		if resume {
+			ResumeOrEnd(handle, "sync")
+			return
+		}
+		plan, err := handle.PlanSync()
+		RunPlanOrEnd(handle, plan, err)
 	},
 }
 
@@ -85,14 +212,39 @@ var statsCmd = &cobra.Command{
 	Use:   "stats",
 	Short: "Display statistics about a jbov",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		handle := OpenOrEnd()
+		summary, err := handle.Summary()
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		for _, cname := range handle.Writable() {
+			stat := summary.Volumes[cname]
+			state := ""
+			if handle.Jbov.Volumes[cname].Deprecated {
+				state = " (deprecated)"
+			}
+			fmt.Printf("%s%s: %d file(s), %d byte(s)\n", cname, state, stat.Files, stat.Bytes)
+		}
+		for _, cname := range handle.Missing() {
+			fmt.Printf("%s: missing\n", cname)
+		}
+		fmt.Printf("%d file(s), %d byte(s) in %d replica(s); %d file(s) with fewer copies than their rules require.\n",
+			summary.Files, summary.Bytes, summary.Replicas, summary.UnderReplicated)
 	},
 }
 
-var rebalanceCmd = &cobra.Command{
-	Use:   "rebalance",
-	Short: "Rebalance a jbov",
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Removes stale locks of a jbov",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		handle := OpenOrEnd()
+		for cname, info := range handle.Locks() {
+			fmt.Printf("%s: locked by %s, stale: %v\n", cname, info, info.IsStale())
+		}
+		unlocked, err := handle.Unlock(forceUnlock)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		fmt.Printf("Unlocked %d volume(s).\n", len(unlocked))
 	},
 }
@@ -6,10 +6,14 @@ import (
 	"os/user"
 	"path/filepath"
 	"github.com/kuking/jbov/api"
+	"github.com/kuking/jbov/api/hashing"
 	"github.com/kuking/jbov/api/md"
 	"github.com/spf13/cobra"
 )
 
+var signed bool
+var hashAlgorithm string
+
 var createCmd = &cobra.Command{
 	Use:   "create name [vol_alias:/path]...",
 	Short: "Creates a new a jbov",
@@ -24,15 +28,28 @@ var createCmd = &cobra.Command{
 			Uniqid:         md.GenerateJbovUniqId(),
 			LastMountPoint: "",
 			Volumes:        make(map[string]*md.Volume),
+			HashAlgorithm:  hashAlgorithm,
 		}
 
 		for i := 1; i < len(args); i++ {
 			addVolumeOutOfArg(&jbov, args[i])
 		}
 
+		if signed {
+			if _, err := api.GenerateKey(jbov.Uniqid); err != nil {
+				ErrAndEnd(-1, "Could not generate the signing key: "+err.Error())
+			}
+		}
+
 		if _, err := api.Create(&jbov); err != nil {
+			if signed {
+				api.RemoveKey(jbov.Uniqid)
+			}
 			ErrAndEnd(-1, err.Error())
 		}
+		if signed {
+			fmt.Println("Signing key kept in", api.KeysDir, "(back it up)")
+		}
 
 		fmt.Println("Created!")
 	},
@@ -41,6 +58,16 @@ var createCmd = &cobra.Command{
 }
 
 func addVolumeOutOfArg(jbov *md.JBOV, arg string) {
+	cname, mountPoint := volumeDescriptorOutOfArg(arg)
+	jbov.Volumes[cname] = &md.Volume{
+		Uniqid:         md.GenerateVolumeUniqId(),
+		LastMountPoint: mountPoint,
+		Deprecated:     false,
+	}
+}
+
+// volumeDescriptorOutOfArg parses a volumename:/path/to/it argument, returning the volume cname and its absolute path.
+func volumeDescriptorOutOfArg(arg string) (string, string) {
 	var splited = strings.Split(arg, ":")
 	if len(splited) != 2 {
 		ErrAndEnd(-1, "Volume descriptor should have the format: volumename:/path/to/it")
@@ -63,14 +90,13 @@ func addVolumeOutOfArg(jbov *md.JBOV, arg string) {
 	if err != nil {
 		ErrAndEnd(-1, "Path not valid: "+err.Error())
 	}
-
-	jbov.Volumes[cname] = &md.Volume{
-		Uniqid:         md.GenerateVolumeUniqId(),
-		LastMountPoint: mountPoint,
-		Deprecated:     false,
-	}
+	return cname, mountPoint
 }
 
 func RegisterCreateCommands(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(createCmd)
+
+	createCmd.Flags().BoolVar(&api.AllowSameFilesystem, "allow-same-filesystem", false, "Allows volumes sharing a filesystem, they will not be redundant copies (dangerous)")
+	createCmd.Flags().StringVar(&hashAlgorithm, "hash", hashing.DEFAULT, fmt.Sprintf("Algorithm used to hash the files content, one of %v", hashing.ALGORITHMS))
+	createCmd.Flags().BoolVar(&signed, "sign", false, "Signs the metadata with a key kept in this host, so tampered metadata is detected")
 }
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+	"github.com/kuking/jbov/api/md"
+	"github.com/spf13/cobra"
+)
+
+var trashId, trashOlderThan, trashMaxAge, trashMaxSize string
+
+var rmCmd = &cobra.Command{
+	Use:   "rm path...",
+	Short: "Removes files from every volume, the volumes not available delete them once back",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			ErrAndEnd(-1, "you need to indicate the files to remove.")
+		}
+		handle := OpenOrEnd()
+		for _, path := range args {
+			if DryRun {
+				holders, err := handle.Holders(path)
+				if err != nil {
+					ErrAndEnd(-1, err.Error())
+				}
+				for _, cname := range holders {
+					fmt.Printf("Would remove %s from volume \"%s\"\n", path, cname)
+				}
+				continue
+			}
+			removed, err := handle.Remove(path)
+			for _, cname := range removed {
+				fmt.Printf("Removed %s from volume \"%s\", it is in the trash until purged\n", path, cname)
+			}
+			if err != nil {
+				ErrAndEnd(-1, err.Error())
+			}
+		}
+		for _, cname := range handle.Missing() {
+			fmt.Printf("Volume \"%s\" is missing, it will delete them once back\n", cname)
+		}
+	},
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manages the files deleted or overwritten by jbov, kept in the volumes trash until purged",
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the files in the trash, the most recently trashed first",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle := OpenOrEnd()
+		entries, err := handle.Trash()
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		bytes := int64(0)
+		for _, entry := range entries {
+			fmt.Println(entry)
+			bytes += entry.Size
+		}
+		fmt.Printf("%d file(s), %d byte(s) in the trash.\n", len(entries), bytes)
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore path",
+	Short: "Restores a trashed file, the most recently trashed copy (or the one of --id) in every volume",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrAndEnd(-1, "you need to indicate the file to restore.")
+		}
+		handle := OpenOrEnd()
+		restored, err := handle.RestoreTrash(args[0], trashId)
+		for _, entry := range restored {
+			fmt.Println("Restored", entry)
+		}
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+	},
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Purges the files in the trash, for good",
+	Run: func(cmd *cobra.Command, args []string) {
+		olderThan, err := parseDuration(trashOlderThan)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		handle := OpenOrEnd()
+		files, bytes, err := handle.EmptyTrash(olderThan)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		fmt.Printf("Purged %d file(s), %d byte(s).\n", files, bytes)
+	},
+}
+
+var trashRetentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Shows, or sets, how long files are kept in the trash before being purged",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle := OpenOrEnd()
+		retention := handle.Jbov.TrashRetention()
+		if trashMaxAge != "" || trashMaxSize != "" {
+			if trashMaxAge == "0" || trashMaxAge == "0d" {
+				retention.MaxAgeDays = 0
+			} else if trashMaxAge != "" {
+				maxAge, err := parseDuration(trashMaxAge)
+				if err != nil {
+					ErrAndEnd(-1, err.Error())
+				}
+				if maxAge < 24*time.Hour {
+					ErrAndEnd(-1, fmt.Sprintf("invalid max age \"%s\", the trash is kept for whole days: at least 1d, or 0 for no limit", trashMaxAge))
+				}
+				retention.MaxAgeDays = int(maxAge.Hours() / 24)
+			}
+			if trashMaxSize != "" {
+				maxSize, err := md.ParseSize(trashMaxSize)
+				if err != nil {
+					ErrAndEnd(-1, err.Error())
+				}
+				retention.MaxBytes = maxSize
+			}
+			lock := LockOrEnd(handle, "trash retention")
+			defer lock.Release()
+			handle.Jbov.Trash = &md.TrashRetention{MaxAgeDays: retention.MaxAgeDays, MaxBytes: retention.MaxBytes}
+			if err := handle.Save(); err != nil {
+				ErrAndEnd(-1, err.Error())
+			}
+		}
+		fmt.Printf("Max age: %d day(s), max size: %d byte(s) per volume (0 is no limit).\n", retention.MaxAgeDays, retention.MaxBytes)
+	},
+}
+
+func RegisterFileCommands(rootCmd *cobra.Command) {
+	rootCmd.AddCommand(rmCmd)
+	rootCmd.AddCommand(trashCmd)
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+	trashCmd.AddCommand(trashRetentionCmd)
+
+	trashRestoreCmd.Flags().StringVar(&trashId, "id", "", "Restores the copy trashed by this operation, as listed")
+	trashEmptyCmd.Flags().StringVar(&trashOlderThan, "older-than", "", "Purges only the files trashed longer ago than this, i.e. 7d")
+	trashRetentionCmd.Flags().StringVar(&trashMaxAge, "max-age", "", "Purges the files trashed longer ago than this, i.e. 30d (0 is no limit)")
+	trashRetentionCmd.Flags().StringVar(&trashMaxSize, "max-size", "", "Purges the oldest files over this size per volume, i.e. 500GB or 2TiB (0 is no limit)")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/kuking/jbov/api"
+	"github.com/spf13/cobra"
+)
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Manage the jbov metadata",
+}
+
+var metadataMergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Merges metadata edited independently in different volumes (split-brain)",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle, conflicts, err := api.MergeForks(JbovPath, YesMan)
+		for _, conflict := range conflicts {
+			fmt.Println("Conflict:", conflict)
+		}
+		if err != nil && len(conflicts) > 0 {
+			ErrAndEnd(-1, err.Error()+", use --yes to keep the version in "+JbovPath)
+		}
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		printRuleWarnings(handle)
+		fmt.Printf("Merged! %d volume(s) updated.\n", len(handle.Present()))
+	},
+}
+
+var withCatalog bool
+
+var metadataExportCmd = &cobra.Command{
+	Use:   "export file",
+	Short: "Exports a snapshot of the jbov metadata, signed when the jbov has a signing key",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrAndEnd(-1, "you need to indicate the file to export the metadata to.")
+		}
+		handle := OpenOrEnd()
+		snapshot, err := handle.Export(args[0], withCatalog)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		if snapshot.Signed() {
+			fmt.Println("Exported! The snapshot is signed.")
+		} else {
+			fmt.Println("Exported! The snapshot has a checksum, it detects damage but not tampering (the jbov has no signing key).")
+		}
+	},
+}
+
+var forceRestore bool
+
+var metadataRestoreCmd = &cobra.Command{
+	Use:   "restore file [vol_alias:/path]...",
+	Short: "Restores the jbov metadata out of an exported snapshot",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			ErrAndEnd(-1, "you need to indicate the snapshot file to restore.")
+		}
+		mountPoints := make(map[string]string)
+		for i := 1; i < len(args); i++ {
+			cname, mountPoint := volumeDescriptorOutOfArg(args[i])
+			mountPoints[cname] = mountPoint
+		}
+		handle, err := api.Restore(args[0], mountPoints, forceRestore)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		for _, cname := range handle.Missing() {
+			fmt.Printf("Volume %s not found, it has not been restored.\n", cname)
+		}
+		printRuleWarnings(handle)
+		fmt.Printf("Restored! %d volume(s) stamped.\n", len(handle.Present()))
+	},
+}
+
+func RegisterMetadataCommands(rootCmd *cobra.Command) {
+	rootCmd.AddCommand(metadataCmd)
+	metadataCmd.AddCommand(metadataMergeCmd)
+	metadataCmd.AddCommand(metadataExportCmd)
+	metadataCmd.AddCommand(metadataRestoreCmd)
+
+	metadataExportCmd.Flags().BoolVar(&withCatalog, "with-catalog", false, "Includes the file catalog of every volume, restored onto the volumes without one")
+	metadataRestoreCmd.Flags().BoolVar(&forceRestore, "force", false, "Restores onto volumes whose uniqid does not match the snapshot (dangerous)")
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"github.com/kuking/jbov/api"
+	"github.com/spf13/cobra"
+)
+
+var planOut string
+var resume bool
+var forceRemoval bool
+
+// RunPlanOrEnd prints a plan and applies it; unless it is a dry run, or it is to be saved (--plan-out) and applied
+// later on with "jbov apply". It ends with an error when the plan could not be made or applied, and with exit code 2
+// when the plan has errors; it is not applied then.
+func RunPlanOrEnd(handle *api.Handle, plan *api.Plan, err error) {
+	if err != nil {
+		ErrAndEnd(-1, err.Error())
+	}
+	printPlan(plan)
+	if planOut != "" {
+		if err := plan.Save(planOut); err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		fmt.Printf("Plan saved in %s, apply it with: jbov apply %s\n", planOut, planOut)
+	} else if plan.HasErrors() {
+		fmt.Println("Error: the plan has errors, it is not applied; fix them first.")
+	} else if !DryRun && len(plan.Ops) > 0 {
+		applyOrEnd(handle, plan)
+	}
+	if plan.HasErrors() {
+		os.Exit(2)
+	}
+}
+
+func printPlan(plan *api.Plan) {
+	for _, finding := range plan.Findings {
+		fmt.Printf("%s: %s\n", finding.Severity, finding)
+	}
+	for _, op := range plan.Ops {
+		fmt.Println(op)
+	}
+	for _, cname := range plan.Cnames() {
+		usage := plan.Volumes[cname]
+		free := "unknown"
+		if usage.Free >= 0 {
+			free = fmt.Sprintf("%d byte(s)", usage.Free)
+		}
+		fmt.Printf("%s: +%d byte(s), -%d byte(s), %s free afterwards\n", cname, usage.Added, usage.Removed, free)
+	}
+	if len(plan.Ops) == 0 {
+		fmt.Println("Nothing to do.")
+		return
+	}
+	fmt.Printf("%d operation(s), %d byte(s) to write.\n", len(plan.Ops), plan.Bytes())
+}
+
+func applyOrEnd(handle *api.Handle, plan *api.Plan) {
+	done, err := handle.Apply(plan)
+	if err != nil {
+		ErrAndEnd(-1, fmt.Sprintf("%s (%d of %d operations done, resume it with: jbov %s --resume)", err.Error(), done, len(plan.Ops), plan.Command))
+	}
+	fmt.Printf("Applied %d operation(s).\n", done)
+}
+
+// ResumeOrEnd carries on applying the interrupted plan of the given command, or ends with an error.
+func ResumeOrEnd(handle *api.Handle, command string) {
+	if DryRun {
+		interruption, err := handle.Interruption()
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		if interruption == nil {
+			fmt.Println("Nothing to resume.")
+			return
+		}
+		for i, op := range interruption.Plan.Ops {
+			if !interruption.Done[i] {
+				fmt.Println(op)
+			}
+		}
+		fmt.Printf("%d of %d operation(s) pending.\n", interruption.Pending(), len(interruption.Plan.Ops))
+		return
+	}
+	plan, done, err := handle.Resume(command)
+	if err != nil {
+		ErrAndEnd(-1, err.Error())
+	}
+	fmt.Printf("Resumed %s, applied %d operation(s) of %d.\n", plan.Command, done, len(plan.Ops))
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply plan.json",
+	Short: "Applies a plan saved with --plan-out, as long as the volumes did not change since",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			ErrAndEnd(-1, "you need to indicate the plan to apply.")
+		}
+		plan, err := api.ReadPlan(args[0])
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		handle := OpenOrEnd()
+		printPlan(plan)
+		if plan.HasErrors() {
+			ErrAndEnd(2, "the plan has errors, it is not applied; fix them first.")
+		}
+		if DryRun || len(plan.Ops) == 0 {
+			return
+		}
+		applyOrEnd(handle, plan)
+	},
+}
+
+var rebalanceCmd = &cobra.Command{
+	Use:   "rebalance",
+	Short: "Moves files from the fullest volumes to the emptiest ones, evening their free space",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle := OpenOrEnd()
+		if resume {
+			ResumeOrEnd(handle, "rebalance")
+			return
+		}
+		plan, err := handle.PlanRebalance()
+		RunPlanOrEnd(handle, plan, err)
+	},
+}
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manages the volumes of a jbov",
+}
+
+var volumeRemoveCmd = &cobra.Command{
+	Use:   "remove cname",
+	Short: "Removes a volume from a jbov, copying first the files which would be left without enough copies",
+	Run: func(cmd *cobra.Command, args []string) {
+		if resume {
+			ResumeOrEnd(OpenOrEnd(), "volume remove")
+			return
+		}
+		if len(args) != 1 {
+			ErrAndEnd(-1, "you need to indicate the volume to remove.")
+		}
+		handle := OpenOrEnd()
+		plan, err := handle.PlanVolumeRemoval(args[0], forceRemoval)
+		RunPlanOrEnd(handle, plan, err)
+	},
+}
+
+func RegisterPlanCommands(rootCmd *cobra.Command) {
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(rebalanceCmd)
+	rootCmd.AddCommand(volumeCmd)
+	volumeCmd.AddCommand(volumeRemoveCmd)
+	volumeRemoveCmd.Flags().BoolVar(&forceRemoval, "force", false, "Removes a missing volume, i.e. a dead disk, from the metadata; the files it held are lost")
+
+	for _, cmd := range []*cobra.Command{syncCmd, repairCmd, rebalanceCmd, volumeRemoveCmd} {
+		cmd.Flags().StringVar(&planOut, "plan-out", "", "Saves the plan in this file, to be applied later on with: jbov apply")
+		cmd.Flags().BoolVar(&resume, "resume", false, "Carries on with the interrupted run, instead of planning a new one")
+	}
+}
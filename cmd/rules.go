@@ -1,27 +1,65 @@
 package cmd
 
 import (
-	"log"
+	"fmt"
+	"sort"
+	"strings"
+	"github.com/kuking/jbov/api/md"
 	"github.com/spf13/cobra"
 )
 
-var pattern, atLeastACopyIn, volume string
-var nCopies, ruleNo int
+var pattern, atLeastACopyIn, volume, nCopies string
+var ruleNo, priority int
 var deprecated bool
 
 var ruleCmd = &cobra.Command{
 	Use:   "rule",
 	Short: "Manage redundancy rules",
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
-	},
 }
 
 var ruleAddCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Adds a new redundancy rule",
+	Long: `Adds a new redundancy rule. The pattern is one or more terms separated by spaces, a file must match all of them;
+a leading ! negates a term:
+  *.mk4            glob matched against the file name, in any directory
+  films/**/*.mk4   glob with a slash, matched against the whole path; ** spans directories
+  /notes.txt       glob anchored to the volume root
+  films/           every file beneath the directory
+  re:^films/\d+/   regular expression matched against the whole path
+  size>1GiB        file size, with <, <=, >, >= and B, KB, MB, GB, TB, KiB, MiB, GiB, TiB
+  age>30d          time since last modified, in s, m, h, d or w
+  ext:mkv,mp4      file extension, regardless of case
+i.e. jbov rule add -p "films/ !*.tmp size>1GiB" -c 2
+When rules overlap, the ones with the highest --priority govern a file and override the rest; rules with the same
+priority combine, the file gets the most copies and a copy in every volume they name.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		handle := OpenOrEnd()
+		if deprecated {
+			if volume == "" {
+				ErrAndEnd(-1, "you need to indicate the volume to deprecate with --volume.")
+			}
+			if err := handle.Deprecate(volume, true); err != nil {
+				ErrAndEnd(-1, err.Error())
+			}
+			fmt.Printf("Volume \"%s\" deprecated.\n", volume)
+			return
+		}
+		rule := md.Rule{Pattern: pattern, AtLeastACopyIn: atLeastACopyIn, Priority: priority}
+		if rule.AtLeastACopyIn == "" {
+			rule.AtLeastACopyIn = volume
+		}
+		if nCopies != "" {
+			copies, err := md.ParseCopies(nCopies)
+			if err != nil {
+				ErrAndEnd(-1, err.Error())
+			}
+			rule.Ncopies = copies
+		}
+		if err := handle.AddRule(rule); err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		fmt.Printf("Rule %d added: %s\n", len(handle.Jbov.Rules)-1, describeRule(&rule))
 	},
 }
 
@@ -29,30 +67,142 @@ var ruleDelCmd = &cobra.Command{
 	Use:   "del",
 	Short: "Removes a redundancy rule",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		if ruleNo < 0 {
+			ErrAndEnd(-1, "you need to indicate the rule number to delete with --ruleno, as listed.")
+		}
+		handle := OpenOrEnd()
+		rule, err := handle.DeleteRule(ruleNo)
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		fmt.Printf("Rule %d deleted: %s\n", ruleNo, describeRule(rule))
 	},
 }
 
 var ruleListCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List rules",
+	Short: "List rules, with the files and bytes every rule currently matches",
+	Run: func(cmd *cobra.Command, args []string) {
+		handle := OpenOrEnd()
+		usage, err := handle.RuleUsage()
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		for i := range handle.Jbov.Rules {
+			fmt.Printf("%d: %s; matches %d file(s), %d byte(s)\n", i, describeRule(&handle.Jbov.Rules[i]), usage[i].Files, usage[i].Bytes)
+			if err := handle.Jbov.Rules[i].PatternError(); err != nil {
+				fmt.Printf("   it matches nothing, %s\n", err)
+			}
+		}
+		if len(handle.Jbov.Rules) == 0 {
+			fmt.Println("There are no rules.")
+		}
+		cnames := []string{}
+		for cname := range handle.Jbov.Volumes {
+			cnames = append(cnames, cname)
+		}
+		sort.Strings(cnames)
+		for _, cname := range cnames {
+			if handle.Jbov.Volumes[cname].Deprecated {
+				fmt.Printf("Volume \"%s\" is deprecated.\n", cname)
+			}
+		}
+	},
+}
+
+var ruleExplainCmd = &cobra.Command{
+	Use:   "explain path",
+	Short: "Explains which rules govern a file, where its replicas are and what sync would do with it",
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal("not implemented yet")
+		if len(args) != 1 {
+			ErrAndEnd(-1, "you need to indicate the file to explain.")
+		}
+		handle := OpenOrEnd()
+		explanation, err := handle.Explain(args[0])
+		if err != nil {
+			ErrAndEnd(-1, err.Error())
+		}
+		if explanation.Found {
+			fmt.Printf("%s, %d byte(s)\n", explanation.Path, explanation.Size)
+		} else {
+			fmt.Printf("%s, not found in any volume\n", explanation.Path)
+		}
+		known := true
+		for _, verdict := range explanation.Rules {
+			state := "does not match"
+			if verdict.Unknown {
+				state = "depends on the size or age of the file"
+				known = false
+			} else if verdict.Governs {
+				state = "governs"
+			} else if verdict.Matches {
+				state = "overridden by a higher priority rule"
+			}
+			fmt.Printf("Rule %d: %s; %s\n", verdict.Index, describeRule(&verdict.Rule), state)
+		}
+		if known {
+			requirement := fmt.Sprintf("%d copies", explanation.Ncopies)
+			for _, cname := range explanation.In {
+				requirement += fmt.Sprintf(", at least a copy in \"%s\"", cname)
+			}
+			fmt.Printf("Requires %s.\n", requirement)
+		}
+		for _, cname := range explanation.Replicas {
+			state := ""
+			if handle.Jbov.Volumes[cname].Deprecated {
+				state = " (deprecated, not counted)"
+			}
+			fmt.Printf("Replica in \"%s\"%s\n", cname, state)
+		}
+		for _, cname := range explanation.Unwritable {
+			fmt.Printf("Replica in \"%s\" (not writable, not counted)\n", cname)
+		}
+		if explanation.Deleted {
+			fmt.Printf("It has been removed, the deletion is pending in %s; sync leaves it alone.\n",
+				strings.Join(handle.Jbov.Deleted[explanation.Path].Pending, ", "))
+		}
+		for _, finding := range explanation.Findings {
+			fmt.Printf("%s: %s\n", finding.Severity, finding)
+		}
+		for _, op := range explanation.Ops {
+			fmt.Printf("Sync would %s\n", op)
+		}
+		if len(explanation.Replicas) > 0 && len(explanation.Ops) == 0 && len(explanation.Findings) == 0 && !explanation.Deleted {
+			fmt.Println("Sync has nothing to do.")
+		}
 	},
 }
 
+func describeRule(rule *md.Rule) string {
+	description := fmt.Sprintf("\"%s\"", rule.Pattern)
+	if rule.Ncopies == md.ALL_VOLUMES {
+		description += " a copy in every volume"
+	} else if rule.Ncopies > 0 {
+		description += fmt.Sprintf(" %d copies", rule.Ncopies)
+	}
+	if rule.AtLeastACopyIn != "" {
+		description += fmt.Sprintf(" at least a copy in \"%s\"", rule.AtLeastACopyIn)
+	}
+	if rule.Priority != 0 {
+		description += fmt.Sprintf(" priority %d", rule.Priority)
+	}
+	return description
+}
+
 func RegisterRuleCommands(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(ruleCmd)
 	ruleCmd.AddCommand(ruleAddCmd)
 	ruleCmd.AddCommand(ruleDelCmd)
 	ruleCmd.AddCommand(ruleListCmd)
+	ruleCmd.AddCommand(ruleExplainCmd)
 
 
 	ruleAddCmd.PersistentFlags().StringVarP(&volume, "volume", "V", "", "Volume to apply the rule to")
-	ruleAddCmd.PersistentFlags().StringVarP(&pattern, "pattern", "p", "", "file pattern to apply to the rule")
-	ruleAddCmd.PersistentFlags().IntVarP(&nCopies, "ncopies", "n", -1, "Number of copies to maintain, '*' indicates to hold a copy on every volumen.")
+	ruleAddCmd.PersistentFlags().StringVarP(&pattern, "pattern", "p", "", "file pattern to apply to the rule, see the command help")
+	ruleAddCmd.PersistentFlags().StringVarP(&nCopies, "ncopies", "c", "", "Number of copies to maintain, '*' indicates to hold a copy on every volume which is not deprecated.")
 	ruleAddCmd.PersistentFlags().StringVarP(&atLeastACopyIn, "at-least-a-copy-in", "a", "", "A redundant copy should be held in the indicated Volume")
+	ruleAddCmd.PersistentFlags().IntVar(&priority, "priority", 0, "Rules with a higher priority override the rest, for the files they match")
 	ruleAddCmd.PersistentFlags().BoolVarP(&deprecated, "deprecated", "d", false, "Marks a volume as deprecated (it will not add any new file in it and files in it will not be counted as redundant copies)")
 
-	ruleDelCmd.PersistentFlags().IntVarP(&ruleNo, "ruleno", "n", -1, "Rule number to delete")
+	ruleDelCmd.PersistentFlags().IntVarP(&ruleNo, "ruleno", "r", -1, "Rule number to delete")
 }
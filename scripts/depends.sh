@@ -2,4 +2,6 @@
 
 go get github.com/spf13/cobra
 go get github.com/stretchr/testify/assert
-
+go get lukechampine.com/blake3
+go get github.com/cespare/xxhash/v2
+go get github.com/bmatcuk/doublestar/v4